@@ -0,0 +1,48 @@
+package recws
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Adopt installs an established connection (e.g. upgraded during an auth flow)
+// as the current connection instead of dialing the first one. The url and
+// the request headers are used for the reconnects, like with Dial.
+// The SubscribeHandler, the keepalive and the connect listeners run as on
+// a dialed connection. If the SubscribeHandler or the ReadyCheck fails, the connection is
+// closed and reconnected, and the error is returned.
+//
+// ErrAlreadyDialed is returned if the connection was dialed before.
+func (rc *RecConn) Adopt(conn *websocket.Conn, urlStr string, reqHeader http.Header) error {
+	if conn == nil {
+		return errors.New("recws: adopt: conn cannot be nil")
+	}
+
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		return err
+	}
+
+	rc.mu.Lock()
+	rc.setConn(conn, nil, 0)
+	rc.mu.Unlock()
+
+	rc.verbosef("Dial: adopted connection to %s", rc.GetURL())
+
+	rc.beginConnecting()
+	if err := rc.prepare(); err != nil {
+		rc.close(true, prepareFailedReason(err), err)
+		rc.endConnecting()
+		if !rc.getIsClosed() {
+			rc.startConnect(0)
+		}
+		return err
+	}
+
+	rc.connected(nil, 0)
+	rc.emit(EventConnected, 0, nil)
+	rc.endConnecting()
+
+	return nil
+}
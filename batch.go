@@ -0,0 +1,110 @@
+package recws
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type writeBatch struct {
+	msgs  [][]byte
+	size  int
+	timer *time.Timer
+	sync.Mutex
+}
+
+// take removes and returns the batched messages
+func (b *writeBatch) take() [][]byte {
+	msgs := b.msgs
+	b.msgs, b.size = nil, 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	return msgs
+}
+
+func (rc *RecConn) getWriteBatchConfig() (window time.Duration, maxSize int) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.WriteBatchWindow, rc.WriteBatchMaxSize
+}
+
+// WriteBatched adds data to the write batch, which is written as a single text
+// message once the WriteBatchWindow elapsed or the WriteBatchMaxSize is reached.
+// Without a WriteBatchWindow, data is written right away.
+//
+// Errors of writes after the window elapsed are logged.
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteBatched(data []byte) error {
+	rc.connectLazily()
+
+	window, maxSize := rc.getWriteBatchConfig()
+	if window <= 0 {
+		return rc.WriteMessage(websocket.TextMessage, data)
+	}
+
+	if !rc.isTransportUp() {
+		return ErrNotConnected
+	}
+
+	rc.writeBatch.Lock()
+	rc.writeBatch.msgs = append(rc.writeBatch.msgs, data)
+	rc.writeBatch.size += len(data)
+	if maxSize > 0 && rc.writeBatch.size >= maxSize {
+		msgs := rc.writeBatch.take()
+		rc.writeBatch.Unlock()
+
+		return rc.writeBatched(msgs)
+	}
+	if rc.writeBatch.timer == nil {
+		rc.writeBatch.timer = time.AfterFunc(window, func() {
+			if err := rc.FlushWrites(); err != nil {
+				rc.logf("WriteBatched: %v", err)
+			}
+		})
+	}
+	rc.writeBatch.Unlock()
+
+	return nil
+}
+
+// FlushWrites writes the write batch right away.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) FlushWrites() error {
+	rc.writeBatch.Lock()
+	msgs := rc.writeBatch.take()
+	rc.writeBatch.Unlock()
+
+	return rc.writeBatched(msgs)
+}
+
+// writeBatched writes the messages as a single message, joined by the Concat func
+func (rc *RecConn) writeBatched(msgs [][]byte) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	rc.mu.RLock()
+	concat := rc.Concat
+	rc.mu.RUnlock()
+
+	var data []byte
+	if concat != nil {
+		if err := rc.callHandler("Concat", func() error {
+			data = concat(msgs)
+			return nil
+		}); err != nil {
+			return err
+		}
+	} else {
+		data = bytes.Join(msgs, nil)
+	}
+
+	return rc.WriteMessage(websocket.TextMessage, data)
+}
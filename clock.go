@@ -0,0 +1,49 @@
+package recws
+
+import "time"
+
+// Clock provides the time for the reconnect and keepalive timing,
+// so it can be replaced by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker delivers ticks like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// getClock returns the Clock, or the real clock if not set.
+// The Clock is read without the lock, since it must not change after Dial.
+func (rc *RecConn) getClock() Clock {
+	if rc.Clock == nil {
+		return realClock{}
+	}
+
+	return rc.Clock
+}
+
+// sleep waits for d, or until the connection is closed
+func (rc *RecConn) sleep(d time.Duration) {
+	select {
+	case <-rc.getClock().After(d):
+	case <-rc.getClosedCh():
+	}
+}
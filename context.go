@@ -0,0 +1,177 @@
+package recws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WriteMessageContext is like WriteMessage, but the write is bounded by the ctx.
+// The WriteWait applies if the ctx has no deadline.
+//
+// If the ctx is done before the message is written, ctx.Err() is returned
+// and the connection is kept. A write interrupted by the ctx leaves the
+// connection unusable, so it is reconnected and ctx.Err() is returned.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteMessageContext(ctx context.Context, messageType int, data []byte) error {
+	return rc.writeMessageContext(ctx, "write message", messageType, data)
+}
+
+// WriteJSONContext is like WriteJSON, but the write is bounded by the ctx
+// the same way as WriteMessageContext. An encoding error is returned
+// without closing the connection.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteJSONContext(ctx context.Context, v interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("recws: write json: %w", err)
+	}
+
+	return rc.writeMessageContext(ctx, "write json", websocket.TextMessage, buf.Bytes())
+}
+
+func (rc *RecConn) writeMessageContext(ctx context.Context, op string, messageType int, data []byte) error {
+	rc.connectLazily()
+
+	if err := rc.awaitWriteHold(ctx); err != nil {
+		return err
+	}
+	if err := rc.waitWriteLimit(ctx); err != nil {
+		return err
+	}
+
+	w := rc.lockWriterContext(ctx)
+	if w.conn == nil {
+		return ErrNotConnected
+	}
+	if err := ctx.Err(); err != nil {
+		w.unlock()
+		return err
+	}
+
+	conn := w.conn
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.NetConn().SetWriteDeadline(time.Now())
+	})
+	err := w.writeFrames(messageType, data)
+	stop()
+	_ = conn.SetWriteDeadline(time.Time{})
+	w.unlock()
+
+	if err == nil {
+		rc.counters.written.Add(uint64(len(data)))
+		return nil
+	}
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		rc.closeNormalClosure(err)
+		return nil
+	}
+
+	rc.closeAndReconnectGeneration(w.generation, DisconnectWriteError, err)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return fmt.Errorf("recws: %s: %w", op, err)
+}
+
+// pendingRead is a read started by ReadMessageContext,
+// which is picked up by the next call if the ctx was done first
+type pendingRead struct {
+	done        chan struct{}
+	messageType int
+	data        []byte
+	err         error
+}
+
+// ReadMessageContext is like ReadMessage, but returns ctx.Err() once the ctx
+// is done, without closing the connection. The read continues in the
+// background and its message is returned by the next call of
+// ReadMessageContext or ReadJSONContext, so keep reading with those after a
+// done ctx, instead of ReadMessage. No read deadline is set, as a read that
+// timed out leaves the connection unusable.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadMessageContext(ctx context.Context) (messageType int, message []byte, err error) {
+	rc.connectLazily()
+
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	rc.mu.Lock()
+	read := rc.pendingRead
+	if read == nil {
+		read = &pendingRead{done: make(chan struct{})}
+		rc.pendingRead = read
+		go func() {
+			read.messageType, read.data, read.err = rc.ReadMessage()
+			close(read.done)
+		}()
+	}
+	rc.mu.Unlock()
+
+	select {
+	case <-read.done:
+		rc.mu.Lock()
+		if rc.pendingRead == read {
+			rc.pendingRead = nil
+		}
+		rc.mu.Unlock()
+
+		return read.messageType, read.data, read.err
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+// ReadJSONContext is like ReadJSON, but returns ctx.Err() once the ctx is
+// done, the same way as ReadMessageContext. A decoding error reconnects
+// like with ReadJSON.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadJSONContext(ctx context.Context, v interface{}) error {
+	_, message, err := rc.ReadMessageContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(message, v); err != nil {
+		rc.closeAndReconnect(DisconnectReadError, err)
+		return fmt.Errorf("recws: read json: %w", err)
+	}
+
+	return nil
+}
+
+// ErrReadTimeout is returned by ReadMessageTimeout if no message
+// was read within the timeout
+var ErrReadTimeout = errors.New("websocket: read timeout")
+
+// ReadMessageTimeout is like ReadMessageContext bounded by the timeout,
+// returning ErrReadTimeout without closing the connection if no message
+// was read in time. Other errors reconnect like with ReadMessage.
+// It suits polling for messages in a loop.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadMessageTimeout(timeout time.Duration) (messageType int, message []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	messageType, message, err = rc.ReadMessageContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 0, nil, ErrReadTimeout
+	}
+
+	return messageType, message, err
+}
@@ -0,0 +1,58 @@
+package recws
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+type byteCounters struct {
+	read    atomic.Uint64
+	written atomic.Uint64
+}
+
+type countingReader struct {
+	r       io.Reader
+	counter *atomic.Uint64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.counter.Add(uint64(n))
+	return n, err
+}
+
+// readJSON is like websocket.Conn.ReadJSON, counting the bytes read
+func (rc *RecConn) readJSON(conn *websocket.Conn, v interface{}) error {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return err
+	}
+
+	err = json.NewDecoder(countingReader{r: r, counter: &rc.counters.read}).Decode(v)
+	if err == io.EOF {
+		// One value is expected in the message.
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// BytesRead returns the number of message bytes read over the lifetime
+// of the connection, across reconnects.
+func (rc *RecConn) BytesRead() uint64 {
+	return rc.counters.read.Load()
+}
+
+// BytesWritten returns the number of message bytes written over the lifetime
+// of the connection, across reconnects.
+func (rc *RecConn) BytesWritten() uint64 {
+	return rc.counters.written.Load()
+}
+
+// ResetCounters resets BytesRead and BytesWritten to 0.
+func (rc *RecConn) ResetCounters() {
+	rc.counters.read.Store(0)
+	rc.counters.written.Store(0)
+}
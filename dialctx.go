@@ -0,0 +1,35 @@
+package recws
+
+import (
+	"context"
+	"net/http"
+)
+
+// DialContext is like Dial, but ties the lifetime of the connection to the ctx:
+// once the ctx is done, the connection is shut down gracefully and not
+// reconnected anymore, like with Shutdown. The keepalive and the background
+// reader stop, and the channels of the background reader are closed.
+//
+// Unlike Dial, an invalid url or config is returned.
+// ErrAlreadyDialed is returned if the connection was dialed before.
+func (rc *RecConn) DialContext(ctx context.Context, urlStr string, reqHeader http.Header) error {
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		return err
+	}
+
+	context.AfterFunc(ctx, rc.terminate)
+
+	// Connect
+	rc.startConnect(0)
+	rc.waitFirstAttempt()
+
+	return nil
+}
+
+// terminate shuts the connection down for good, gracefully if connected
+func (rc *RecConn) terminate() {
+	rc.verbosef("Dial: context done, shutting down")
+
+	// Shutdown also shuts down a connection that is not connected
+	_ = rc.Shutdown(rc.getHandshakeTimeout())
+}
@@ -0,0 +1,48 @@
+package recws
+
+import (
+	"fmt"
+	"time"
+)
+
+// DialError is a failed dial attempt recorded in the DialErrorHistory.
+type DialError struct {
+	Time time.Time
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Time.Format(time.RFC3339), e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// recordDialError adds the error to the dial error history.
+// Callers must hold the write lock.
+func (rc *RecConn) recordDialError(err error) {
+	size := rc.DialErrorHistorySize
+	if size <= 0 {
+		return
+	}
+
+	rc.dialErrors = append(rc.dialErrors, &DialError{Time: rc.getClock().Now(), Err: err})
+	if len(rc.dialErrors) > size {
+		rc.dialErrors = append(rc.dialErrors[:0:0], rc.dialErrors[len(rc.dialErrors)-size:]...)
+	}
+}
+
+// DialErrorHistory returns the most recent dial errors as *DialError,
+// oldest first. Empty unless DialErrorHistorySize is set.
+func (rc *RecConn) DialErrorHistory() []error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	history := make([]error, len(rc.dialErrors))
+	for i, err := range rc.dialErrors {
+		history[i] = err
+	}
+
+	return history
+}
@@ -0,0 +1,85 @@
+package recws
+
+import "time"
+
+// EventKind describes the kind of an Event.
+type EventKind int
+
+const (
+	// EventConnected means the connection was established and subscribed.
+	EventConnected EventKind = iota
+	// EventDisconnected means the connection was closed.
+	EventDisconnected
+	// EventReconnecting means a dial attempt is starting.
+	EventReconnecting
+	// EventFailed means a dial attempt failed.
+	EventFailed
+)
+
+// String returns the name of the kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventReconnecting:
+		return "reconnecting"
+	case EventFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a connection event delivered by Events.
+type Event struct {
+	Kind      EventKind
+	Timestamp time.Time
+	// Attempt is the dial attempt of the connect loop, 0 for EventDisconnected
+	Attempt int
+	Err     error
+	URL     string
+}
+
+// Events returns the channel of connection events, the structured counterpart
+// of the log messages. Events are only recorded after the first call, and
+// dropped if the channel buffer (EventBufferSize) is full.
+func (rc *RecConn) Events() <-chan Event {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.events == nil {
+		size := rc.EventBufferSize
+		if size <= 0 {
+			size = defaultEventBufferSize
+		}
+		rc.events = make(chan Event, size)
+	}
+
+	return rc.events
+}
+
+const defaultEventBufferSize = 64
+
+// emit records the event if Events was called
+func (rc *RecConn) emit(kind EventKind, attempt int, err error) {
+	rc.mu.RLock()
+	events, urlStr := rc.events, rc.url
+	rc.mu.RUnlock()
+
+	if events == nil {
+		return
+	}
+
+	select {
+	case events <- Event{
+		Kind:      kind,
+		Timestamp: rc.getClock().Now(),
+		Attempt:   attempt,
+		Err:       err,
+		URL:       urlStr,
+	}:
+	default:
+	}
+}
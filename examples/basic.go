@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"time"
 
@@ -32,12 +33,16 @@ func main() {
 				continue
 			}
 
-			if err := ws.WriteMessage(1, []byte("Incoming")); err != nil {
-				log.Printf("Error: WriteMessage %s", ws.GetURL())
+			if err := ws.WriteString("Incoming"); err != nil {
+				log.Printf("Error: WriteString %s", ws.GetURL())
 				return
 			}
 
 			_, message, err := ws.ReadMessage()
+			if errors.Is(err, recws.ErrClosedByServer) {
+				log.Printf("Websocket closed by server %s", ws.GetURL())
+				return
+			}
 			if err != nil {
 				log.Printf("Error: ReadMessage %s", ws.GetURL())
 				return
@@ -0,0 +1,63 @@
+package recws
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+const framePrefixSize = 4
+
+// ErrInvalidFrame is returned by ReadFramed when a message
+// is not a valid sequence of length-prefixed sub-messages
+var ErrInvalidFrame = errors.New("websocket: invalid length-prefixed frame")
+
+// ReadFramed reads the next message and splits it into sub-messages,
+// each prefixed with its length as a 4-byte big-endian integer.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadFramed() ([][]byte, error) {
+	_, message, err := rc.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs [][]byte
+	for len(message) > 0 {
+		if len(message) < framePrefixSize {
+			return nil, fmt.Errorf("recws: read framed: %w", ErrInvalidFrame)
+		}
+
+		size := binary.BigEndian.Uint32(message)
+		message = message[framePrefixSize:]
+		if uint64(size) > uint64(len(message)) {
+			return nil, fmt.Errorf("recws: read framed: %w", ErrInvalidFrame)
+		}
+
+		msgs = append(msgs, message[:size:size])
+		message = message[size:]
+	}
+
+	return msgs, nil
+}
+
+// WriteFramed writes msgs as a single binary message,
+// each prefixed with its length as a 4-byte big-endian integer.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteFramed(msgs [][]byte) error {
+	size := 0
+	for _, msg := range msgs {
+		size += framePrefixSize + len(msg)
+	}
+
+	data := make([]byte, 0, size)
+	for _, msg := range msgs {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(msg)))
+		data = append(data, msg...)
+	}
+
+	return rc.WriteMessage(websocket.BinaryMessage, data)
+}
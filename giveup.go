@@ -0,0 +1,92 @@
+package recws
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+func (rc *RecConn) getMaxReconnectAttempts() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.MaxReconnectAttempts
+}
+
+// shouldGiveUp reports whether the MaxReconnectAttempts are exhausted
+func (rc *RecConn) shouldGiveUp(failures int) bool {
+	maxAttempts := rc.getMaxReconnectAttempts()
+
+	return maxAttempts > 0 && failures >= maxAttempts
+}
+
+// isFailFastStatus reports whether the handshake response status is one of the FailFastStatusCodes
+func (rc *RecConn) isFailFastStatus(httpResp *http.Response) bool {
+	if httpResp == nil {
+		return false
+	}
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return slices.Contains(rc.FailFastStatusCodes, httpResp.StatusCode)
+}
+
+// giveUp stops reconnecting after the last failed attempt
+func (rc *RecConn) giveUp(failures int, err error) {
+	err = fmt.Errorf("recws: gave up after %d attempts: %w", failures, err)
+	rc.logf("Dial: %v", err)
+
+	rc.mu.Lock()
+	giveUpHandler, waiters := rc.GiveUpHandler, rc.giveUpWaiters
+	rc.giveUpWaiters = nil
+	rc.mu.Unlock()
+
+	for _, waiter := range waiters {
+		waiter(err)
+	}
+
+	if giveUpHandler != nil {
+		_ = rc.callHandler("GiveUpHandler", func() error {
+			giveUpHandler(err)
+			return nil
+		})
+	}
+}
+
+// DialFuture is like Dial, but returns right away with a channel delivering
+// the result of the first connection: nil once connected, or the error when
+// the MaxReconnectAttempts are exhausted before. The channel is closed after
+// the result.
+func (rc *RecConn) DialFuture(urlStr string, reqHeader http.Header) <-chan error {
+	var (
+		result = make(chan error, 1)
+		once   sync.Once
+	)
+	resolve := func(err error) {
+		once.Do(func() {
+			result <- err
+			close(result)
+		})
+	}
+
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		resolve(err)
+		return result
+	}
+
+	var remove func()
+	remove = rc.AddConnectListener(func() {
+		resolve(nil)
+		remove()
+	})
+
+	rc.mu.Lock()
+	rc.giveUpWaiters = append(rc.giveUpWaiters, resolve)
+	rc.mu.Unlock()
+
+	rc.startConnect(0)
+
+	return result
+}
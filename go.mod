@@ -5,4 +5,5 @@ go 1.23
 require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/jpillora/backoff v1.0.0
+	golang.org/x/time v0.8.0
 )
@@ -0,0 +1,327 @@
+package recws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// callHandler runs a user provided handler, recovering from a panic.
+// A recovered panic is passed to HandlerPanicHandler, or logged if it is not set,
+// and returned as an error, so a panicking handler fails like one returning an error.
+func (rc *RecConn) callHandler(which string, fn func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			rc.handlerPanicked(which, recovered)
+			err = &handlerPanicError{which: which, recovered: recovered}
+		}
+	}()
+
+	return fn()
+}
+
+// handlerPanicError is returned by callHandler for a recovered panic
+type handlerPanicError struct {
+	which     string
+	recovered interface{}
+}
+
+func (e *handlerPanicError) Error() string {
+	return fmt.Sprintf("recws: %s panicked: %v", e.which, e.recovered)
+}
+
+// isHandlerPanic reports whether err is a panic recovered by callHandler
+func isHandlerPanic(err error) bool {
+	var panicErr *handlerPanicError
+	return errors.As(err, &panicErr)
+}
+
+func (rc *RecConn) handlerPanicked(which string, recovered interface{}) {
+	rc.mu.RLock()
+	handlerPanicHandler := rc.HandlerPanicHandler
+	rc.mu.RUnlock()
+
+	if handlerPanicHandler == nil {
+		rc.logf("recws: %s panicked: %v", which, recovered)
+		return
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			rc.logf("recws: handler panic handler panicked: %v", recovered)
+		}
+	}()
+	handlerPanicHandler(which, recovered)
+}
+
+func (rc *RecConn) getSubscribeContextHandler() func(ctx context.Context) error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SubscribeContextHandler
+}
+
+// handlerContext returns the ctx for the handlers, with the values of the BaseContext
+func (rc *RecConn) handlerContext() context.Context {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.BaseContext == nil {
+		return context.Background()
+	}
+
+	return context.WithoutCancel(rc.BaseContext)
+}
+
+func (rc *RecConn) getSubscribeDelay() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SubscribeDelay
+}
+
+func (rc *RecConn) getSubscribeTimeout() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SubscribeTimeout
+}
+
+// prepareConnKey carries the connection being prepared in the ctx of the
+// SubscribeContextHandler, whose ctx writes go to it, see MakeBeforeBreak.
+type prepareConnKey struct{}
+
+// isPrepareConnUp reports whether the connection being prepared is still up,
+// a connection not installed yet is up until the RecConn is closed
+func (rc *RecConn) isPrepareConnUp(conn *websocket.Conn) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if conn != rc.Conn {
+		return !rc.isClosed
+	}
+
+	return rc.transportUp
+}
+
+// subscribe runs the subscribe handler on the connection, bounded by the
+// SubscribeTimeout. ErrSubscribeTimeout is returned if the handler did not
+// complete in time.
+func (rc *RecConn) subscribe(conn *websocket.Conn) error {
+	handler := rc.getSubscribeContextHandler()
+	if handler == nil {
+		subscribeHandler := rc.getSubscribeHandler()
+		if subscribeHandler == nil {
+			return nil
+		}
+		handler = func(context.Context) error { return subscribeHandler() }
+	}
+
+	if delay := rc.getSubscribeDelay(); delay > 0 {
+		rc.sleep(time.Duration(rand.Int63n(int64(delay)) + 1))
+		if !rc.isPrepareConnUp(conn) {
+			return ErrNotConnected
+		}
+	}
+
+	ctx := context.WithValue(rc.handlerContext(), prepareConnKey{}, conn)
+	ctx, cancel := context.WithValue(ctx, holdBypassKey{}, true), func() {}
+	timeout := rc.getSubscribeTimeout()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rc.callHandler("SubscribeHandler", func() error { return handler(ctx) })
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ErrSubscribeTimeout
+	}
+
+	rc.verbosef("Dial: connect handler was successfully established with %s", rc.GetURL())
+
+	return nil
+}
+
+// ErrNotReady wraps the error of a failed ReadyCheck
+var ErrNotReady = errors.New("websocket: ready check failed")
+
+// prepare runs the subscribe handler and the ReadyCheck on a new connection,
+// holding the writes if HoldWritesUntilSubscribed is set
+func (rc *RecConn) prepare() error {
+	release := rc.holdWrites()
+	defer release()
+
+	return rc.prepareConn(rc.getConn())
+}
+
+// prepareConn runs the subscribe handler and the ReadyCheck on the connection,
+// which may not be installed yet
+func (rc *RecConn) prepareConn(conn *websocket.Conn) error {
+	err := rc.subscribe(conn)
+	if err == nil && !rc.isPrepareConnUp(conn) {
+		// closed by the subscribe handler
+		err = ErrNotConnected
+	}
+	if err != nil {
+		return err
+	}
+
+	rc.mu.RLock()
+	readyCheck := rc.ReadyCheck
+	rc.mu.RUnlock()
+
+	if readyCheck == nil {
+		return nil
+	}
+
+	if err := rc.callHandler("ReadyCheck", readyCheck); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotReady, err)
+	}
+	if !rc.isPrepareConnUp(conn) {
+		return ErrNotConnected
+	}
+
+	return nil
+}
+
+// prepareFailedReason returns the disconnect reason for an error of prepare
+func prepareFailedReason(err error) DisconnectReason {
+	if errors.Is(err, ErrNotReady) {
+		return DisconnectNotReady
+	}
+
+	return DisconnectSubscribeFailed
+}
+
+// SetSubscribeHandler sets the SubscribeHandler.
+// The change takes effect on the next reconnect.
+func (rc *RecConn) SetSubscribeHandler(handler func() error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.SubscribeHandler = handler
+}
+
+// SetPongHandler sets the PongHandler.
+// The change takes effect immediately.
+func (rc *RecConn) SetPongHandler(handler func(appData string) error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.PongHandler = handler
+}
+
+// SetDisconnectHandler sets the DisconnectHandler.
+// The change takes effect on the next disconnect.
+func (rc *RecConn) SetDisconnectHandler(handler func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.DisconnectHandler = handler
+}
+
+func (rc *RecConn) getSubscribeHandler() func() error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SubscribeHandler
+}
+
+func (rc *RecConn) getDisconnectHandler() func() {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.DisconnectHandler
+}
+
+func (rc *RecConn) getDisconnectContextHandler() func(ctx context.Context) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.DisconnectContextHandler
+}
+
+func (rc *RecConn) getAsyncDisconnectHandler() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.AsyncDisconnectHandler
+}
+
+func (rc *RecConn) getPongHandler() func(appData string) error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.PongHandler
+}
+
+// installPongHandler sets the pong handler on the connection.
+// onPong runs before the PongHandler.
+// The PongHandler is looked up on every pong, so SetPongHandler applies immediately.
+func (rc *RecConn) installPongHandler(conn *websocket.Conn, onPong func()) {
+	conn.SetPongHandler(func(appData string) error {
+		onPong()
+		pongHandler := rc.getPongHandler()
+		if pongHandler == nil {
+			return nil
+		}
+
+		err := rc.callHandler("PongHandler", func() error {
+			return pongHandler(appData)
+		})
+		if err == nil {
+			return nil
+		}
+		if rc.getPongHandlerSwallowError() {
+			rc.logf("recws: pong handler: %v", err)
+			return nil
+		}
+
+		return fmt.Errorf("recws: pong handler: %w", err)
+	})
+}
+
+// installCloseHandler installs the close handler on the connection,
+// which passes the received close frame to the CloseFrameHandler before
+// replying with a close frame like the gorilla default
+func (rc *RecConn) installCloseHandler(conn *websocket.Conn) {
+	conn.SetCloseHandler(func(code int, text string) error {
+		if closeFrameHandler := rc.getCloseFrameHandler(); closeFrameHandler != nil {
+			_ = rc.callHandler("CloseFrameHandler", func() error {
+				closeFrameHandler(code, []byte(text))
+				return nil
+			})
+		}
+
+		msg := websocket.FormatCloseMessage(code, "")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return nil
+	})
+}
+
+func (rc *RecConn) getCloseFrameHandler() func(code int, payload []byte) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.CloseFrameHandler
+}
+
+func (rc *RecConn) getPongHandlerSwallowError() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.PongHandlerSwallowError
+}
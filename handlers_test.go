@@ -0,0 +1,296 @@
+package recws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/recws-org/recws/recwstest"
+)
+
+func TestReadyCheckBeforeConnected(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	var (
+		connectedInCheck = true
+		checked          = make(chan error, 1)
+	)
+	rc := newTestConn()
+	rc.ReadyCheck = func() error {
+		connectedInCheck = rc.IsConnected()
+
+		// the echo server replies to the hello with the ack
+		if err := rc.WriteString("hello"); err != nil {
+			checked <- err
+			return err
+		}
+		_, ack, err := rc.ReadMessage()
+		if err == nil && string(ack) != "hello" {
+			err = errors.New("unexpected ack " + string(ack))
+		}
+		checked <- err
+		return err
+	}
+	messages := rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+
+	select {
+	case err := <-checked:
+		if err != nil {
+			t.Fatalf("ready check: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ready check did not run")
+	}
+	waitFor(t, "connect", rc.IsConnected)
+	if connectedInCheck {
+		t.Fatal("IsConnected was true during the ReadyCheck")
+	}
+
+	if err := rc.WriteString("after"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if string(msg.Data) != "after" {
+			t.Fatalf("background reader got %q, want the message after the ReadyCheck", msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background reader got no message")
+	}
+}
+
+func TestPanickingReadyCheckFails(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	panicked := make(chan string, 1)
+	rc := newTestConn()
+	rc.HandlerPanicHandler = func(which string, _ interface{}) {
+		select {
+		case panicked <- which:
+		default:
+		}
+	}
+	rc.ReadyCheck = func() error { panic("boom") }
+	rc.Dial(srv.WSURL(), nil)
+
+	select {
+	case which := <-panicked:
+		if which != "ReadyCheck" {
+			t.Fatalf("got panic of %s, want ReadyCheck", which)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadyCheck did not run")
+	}
+	waitFor(t, "a retry", func() bool { return srv.Connections() >= 2 })
+	if rc.IsConnected() {
+		t.Fatal("connected after a panicking ReadyCheck")
+	}
+}
+
+// recordPanics records the callbacks recovered by the HandlerPanicHandler
+func recordPanics(rc *RecConn) (panicked func(which string) bool) {
+	var (
+		mu    sync.Mutex
+		which = make(map[string]bool)
+	)
+	rc.HandlerPanicHandler = func(w string, _ interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		which[w] = true
+	}
+
+	return func(w string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return which[w]
+	}
+}
+
+func TestPanickingCallbacksAreRecovered(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	var dials atomic.Int32
+	rc := newTestConn()
+	panicked := recordPanics(rc)
+	rc.WriteQueueSize = 4
+	rc.MessageBufferSize = 1
+	rc.WriteBatchWindow = time.Hour
+	rc.Metrics = Metrics{
+		OnConnect:       func(time.Duration, map[string]string) { panic("OnConnect") },
+		OnReaderBlocked: func(time.Duration, map[string]string) { panic("OnReaderBlocked") },
+		OnWriteQueue:    func(int, map[string]string) { panic("OnWriteQueue") },
+	}
+	rc.MessageFilter = func(messageType int) bool {
+		if messageType == websocket.BinaryMessage {
+			panic("MessageFilter")
+		}
+		return true
+	}
+	rc.Concat = func([][]byte) []byte { panic("Concat") }
+	rc.PreDial = func(ctx context.Context) (net.Conn, error) {
+		if dials.Add(1) == 1 {
+			panic("PreDial")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("queued")); err != nil {
+		t.Fatal(err)
+	}
+	messages := rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	receive := func(want string) {
+		t.Helper()
+		select {
+		case msg := <-messages:
+			if string(msg.Data) != want {
+				t.Fatalf("got %q, want %q", msg.Data, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	receive("queued")
+
+	// the binary message is dropped by the panicking filter
+	if err := rc.WriteMessage(websocket.BinaryMessage, []byte("binary")); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "two"} {
+		if err := rc.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// let the reader block on the full buffer
+	time.Sleep(50 * time.Millisecond)
+	receive("one")
+	receive("two")
+
+	// the panicking match does not match, the message is delivered to Messages
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := rc.Request(ctx, "request", func(json.RawMessage) bool { panic("match") }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want no response", err)
+	}
+	receive("\"request\"\n")
+
+	if err := rc.WriteBatched([]byte("batched")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.FlushWrites(); err == nil {
+		t.Fatal("got no error for the panicking Concat")
+	}
+
+	for _, which := range []string{"PreDial", "OnConnect", "OnWriteQueue", "MessageFilter", "OnReaderBlocked", "Request match", "Concat"} {
+		if !panicked(which) {
+			t.Errorf("the panic of %s was not recovered", which)
+		}
+	}
+}
+
+func TestPanickingDialCallbacksFailTheAttempt(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var proxies, dials atomic.Int32
+	rc := newTestConn()
+	panicked := recordPanics(rc)
+	rc.Proxy = func(*http.Request) (*url.URL, error) {
+		if proxies.Add(1) == 1 {
+			panic("Proxy")
+		}
+		return nil, nil
+	}
+	rc.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if dials.Add(1) == 1 {
+			panic("NetDialContext")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	waitFor(t, "connect", rc.IsConnected)
+	for _, which := range []string{"Proxy", "NetDialContext"} {
+		if !panicked(which) {
+			t.Errorf("the panic of %s was not recovered", which)
+		}
+	}
+}
+
+func TestHandlersInstalledBeforeRead(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		// a pong and a close frame right after the handshake
+		_ = ws.WriteControl(websocket.PongMessage, []byte("early"), time.Now().Add(time.Second))
+		_ = ws.WriteMessage(websocket.TextMessage, []byte("hello"))
+		_ = ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "bye"), time.Now().Add(time.Second))
+		_, _, _ = ws.ReadMessage()
+	}))
+	defer srv.Close()
+
+	var pongs, closeFrames atomic.Int32
+	read := make(chan struct{})
+	rc := newTestConn()
+	rc.PongHandler = func(string) error {
+		pongs.Add(1)
+		return nil
+	}
+	rc.CloseFrameHandler = func(int, []byte) {
+		closeFrames.Add(1)
+	}
+	rc.SubscribeContextHandler = func(ctx context.Context) error {
+		select {
+		case <-read:
+			return nil
+		default:
+		}
+		defer close(read)
+
+		// reads before the connection is marked as connected
+		if _, _, err := rc.ReadMessage(); err != nil {
+			return err
+		}
+		_, _, _ = rc.ReadMessageRaw()
+		return nil
+	}
+	rc.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	defer rc.Close()
+
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscribe handler")
+	}
+	if got := pongs.Load(); got != 1 {
+		t.Errorf("PongHandler called %d times, want 1", got)
+	}
+	if got := closeFrames.Load(); got != 1 {
+		t.Errorf("CloseFrameHandler called %d times, want 1", got)
+	}
+}
@@ -0,0 +1,54 @@
+package recws
+
+import "context"
+
+// holdBypassKey marks the ctx of the SubscribeContextHandler,
+// whose writes are not held by HoldWritesUntilSubscribed.
+// Request rejects it, see ErrRequestInSubscribe.
+type holdBypassKey struct{}
+
+// holdWrites holds the writes until the returned func is called,
+// if HoldWritesUntilSubscribed is set
+func (rc *RecConn) holdWrites() (release func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if !rc.HoldWritesUntilSubscribed {
+		return func() {}
+	}
+
+	hold := make(chan struct{})
+	rc.writeHold = hold
+
+	return func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		close(hold)
+		if rc.writeHold == hold {
+			rc.writeHold = nil
+		}
+	}
+}
+
+// awaitWriteHold waits until held writes are released, or the ctx is done
+func (rc *RecConn) awaitWriteHold(ctx context.Context) error {
+	if ctx.Value(holdBypassKey{}) != nil {
+		return nil
+	}
+
+	rc.mu.RLock()
+	hold := rc.writeHold
+	rc.mu.RUnlock()
+
+	if hold == nil {
+		return nil
+	}
+
+	select {
+	case <-hold:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,104 @@
+package recws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/recws-org/recws/recwstest"
+)
+
+func TestHoldWritesUntilSubscribedValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		rc      *RecConn
+		invalid bool
+	}{
+		{"SubscribeHandler", &RecConn{
+			HoldWritesUntilSubscribed: true,
+			SubscribeHandler:          func() error { return nil },
+		}, true},
+		{"ReadyCheck", &RecConn{
+			HoldWritesUntilSubscribed: true,
+			SubscribeContextHandler:   func(context.Context) error { return nil },
+			ReadyCheck:                func() error { return nil },
+		}, true},
+		{"SubscribeContextHandler", &RecConn{
+			HoldWritesUntilSubscribed: true,
+			SubscribeContextHandler:   func(context.Context) error { return nil },
+		}, false},
+	} {
+		err := test.rc.Validate()
+		if got := errors.Is(err, ErrInvalidConfig); got != test.invalid {
+			t.Errorf("%s: got %v, want invalid %t", test.name, err, test.invalid)
+		}
+	}
+}
+
+func TestHoldWritesUntilSubscribed(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	subscribing := make(chan struct{})
+	subscribed := make(chan struct{})
+	rc := newTestConn()
+	rc.HoldWritesUntilSubscribed = true
+	rc.SubscribeContextHandler = func(ctx context.Context) error {
+		if srv.Connections() > 1 {
+			return nil
+		}
+		if err := rc.WriteMessageContext(ctx, websocket.TextMessage, []byte("subscribe")); err != nil {
+			return err
+		}
+		close(subscribing)
+		<-subscribed
+		return nil
+	}
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	<-subscribing
+	written := make(chan error, 1)
+	go func() { written <- rc.WriteMessage(websocket.TextMessage, []byte("app")) }()
+	select {
+	case err := <-written:
+		t.Fatalf("write returned %v while subscribing, want it held", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(subscribed)
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"subscribe", "app"} {
+		if _, got, err := rc.ReadMessage(); err != nil || string(got) != want {
+			t.Fatalf("got %q, %v, want the echo of %q", got, err, want)
+		}
+	}
+}
+
+func TestRequestInSubscribe(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	errs := make(chan error, 1)
+	rc := newTestConn()
+	rc.SubscribeContextHandler = func(ctx context.Context) error {
+		_, err := rc.Request(ctx, "hello", func(json.RawMessage) bool { return true })
+		select {
+		case errs <- err:
+		default:
+		}
+		return nil
+	}
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	if err := <-errs; !errors.Is(err, ErrRequestInSubscribe) {
+		t.Fatalf("got %v, want ErrRequestInSubscribe", err)
+	}
+	waitFor(t, "connect", rc.IsConnected)
+}
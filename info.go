@@ -0,0 +1,40 @@
+package recws
+
+import "time"
+
+// ConnInfo is a snapshot of the connection state.
+type ConnInfo struct {
+	URL                string
+	Connected          bool
+	Reconnecting       bool
+	Closed             bool
+	ReconnectCount     int
+	LastConnectedAt    time.Time
+	LastDisconnectedAt time.Time
+	LastMessageAt      time.Time
+	LastDialError      error
+	LastCloseCode      int
+	ReconnectInterval  time.Duration
+}
+
+// Info returns a snapshot of the connection state, captured under a single lock.
+func (rc *RecConn) Info() ConnInfo {
+	lastMessageAt := rc.LastMessageAt()
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return ConnInfo{
+		URL:                rc.url,
+		Connected:          rc.isConnected,
+		Reconnecting:       rc.isReconnecting.Load(),
+		Closed:             rc.isClosed,
+		ReconnectCount:     rc.reconnectCount,
+		LastConnectedAt:    rc.lastConnectedAt,
+		LastDisconnectedAt: rc.lastDisconnectedAt,
+		LastMessageAt:      lastMessageAt,
+		LastDialError:      rc.dialErr,
+		LastCloseCode:      rc.lastCloseCode,
+		ReconnectInterval:  rc.nextItvl,
+	}
+}
@@ -7,14 +7,15 @@ import (
 
 type keepAliveResponse struct {
 	lastResponse time.Time
+	lastPing     time.Time
 	sync.RWMutex
 }
 
-func (k *keepAliveResponse) setLastResponse() {
+func (k *keepAliveResponse) setLastResponse(t time.Time) {
 	k.Lock()
 	defer k.Unlock()
 
-	k.lastResponse = time.Now()
+	k.lastResponse = t
 }
 
 func (k *keepAliveResponse) getLastResponse() time.Time {
@@ -23,3 +24,22 @@ func (k *keepAliveResponse) getLastResponse() time.Time {
 
 	return k.lastResponse
 }
+
+func (k *keepAliveResponse) setLastPing(t time.Time) {
+	k.Lock()
+	defer k.Unlock()
+
+	k.lastPing = t
+}
+
+// takeLastPing returns the time of the last ping and resets it,
+// so that each ping is matched with a single pong
+func (k *keepAliveResponse) takeLastPing() time.Time {
+	k.Lock()
+	defer k.Unlock()
+
+	lastPing := k.lastPing
+	k.lastPing = time.Time{}
+
+	return lastPing
+}
@@ -0,0 +1,226 @@
+package recws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/recws-org/recws/recwstest"
+)
+
+// newSilentServer starts a server that accepts connections and never reads,
+// so it neither answers pings nor close messages
+func newSilentServer(t *testing.T) (wsURL string, connections *atomic.Int32) {
+	t.Helper()
+
+	var (
+		upgrader websocket.Upgrader
+		done     = make(chan struct{})
+	)
+	connections = new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		connections.Add(1)
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), connections
+}
+
+func TestShutdownRacingKeepAlive(t *testing.T) {
+	const keepAlive = 100 * time.Millisecond
+
+	for _, offset := range []time.Duration{80, 95, 100, 105, 120} {
+		wsURL, connections := newSilentServer(t)
+
+		rc := newTestConn()
+		rc.KeepAliveTimeout = keepAlive
+		rc.QuietKeepAlive = true
+		rc.Dial(wsURL, nil)
+		waitFor(t, "connect", rc.IsConnected)
+
+		// the keepalive finds no pong and reconnects around the Shutdown
+		time.Sleep(offset * time.Millisecond)
+		_ = rc.Shutdown(keepAlive)
+		// let the server count a dial that was in flight before the Shutdown
+		time.Sleep(20 * time.Millisecond)
+		want := connections.Load()
+		rc.CloseAndReconnect()
+		time.Sleep(3 * keepAlive)
+
+		if got := connections.Load(); got != want {
+			t.Fatalf("offset %dms: got %d connections, want no reconnect after Shutdown", offset, got-want)
+		}
+		if rc.IsConnected() || rc.IsReconnecting() {
+			t.Fatalf("offset %dms: connected %t, reconnecting %t after Shutdown", offset, rc.IsConnected(), rc.IsReconnecting())
+		}
+	}
+}
+
+// disconnectReasons records the disconnect reasons of the RecConn
+func disconnectReasons(rc *RecConn) func() []DisconnectReason {
+	var (
+		mu      sync.Mutex
+		reasons []DisconnectReason
+	)
+	rc.AddDisconnectListener(func(reason DisconnectReason, _ error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons = append(reasons, reason)
+	})
+
+	return func() []DisconnectReason {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]DisconnectReason(nil), reasons...)
+	}
+}
+
+func TestFailedPingReconnects(t *testing.T) {
+	const keepAlive = 100 * time.Millisecond
+
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	conns := make(chan *faultyConn, 2)
+	rc := newTestConn()
+	rc.KeepAliveTimeout = keepAlive
+	rc.QuietKeepAlive = true
+	rc.NetDialContext = faultyDialer(conns)
+	reasons := disconnectReasons(rc)
+	// the background reader processes the pongs
+	rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	start := time.Now()
+	(<-conns).fail.Store(true)
+	waitFor(t, "reconnect", func() bool { return srv.Connections() == 2 && rc.IsConnected() })
+
+	if elapsed := time.Since(start); elapsed > keepAlive+keepAlive/2 {
+		t.Fatalf("reconnected after %v, want it on the next ping", elapsed)
+	}
+	if got := reasons(); len(got) != 1 || got[0] != DisconnectWriteError {
+		t.Fatalf("got disconnect reasons %v, want a single DisconnectWriteError", got)
+	}
+}
+
+func TestOverlappingReconnectTriggers(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	rc := newTestConn()
+	reasons := disconnectReasons(rc)
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	// a read error and a keepalive timeout of the same connection at once
+	generation := rc.getGeneration()
+	var wg sync.WaitGroup
+	for _, reason := range []DisconnectReason{DisconnectReadError, DisconnectKeepAliveTimeout} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc.closeAndReconnectGeneration(generation, reason, nil)
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "reconnect", func() bool { return rc.IsConnected() && !rc.IsReconnecting() })
+
+	// a late trigger of the old connection leaves the new one alone
+	rc.closeAndReconnectGeneration(generation, DisconnectKeepAliveTimeout, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	if !rc.IsConnected() || rc.getGeneration() == generation {
+		t.Fatal("the new connection was closed by a trigger of the old one")
+	}
+	if got := srv.Connections(); got != 2 {
+		t.Fatalf("got %d connections, want a single reconnect", got)
+	}
+	if got := reasons(); len(got) != 1 {
+		t.Fatalf("got disconnect reasons %v, want a single disconnect", got)
+	}
+}
+
+func TestPingsDuringDataFlood(t *testing.T) {
+	const keepAlive = 100 * time.Millisecond
+
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var (
+		mu    sync.Mutex
+		pings []time.Time
+	)
+	conns := make(chan *faultyConn, 1)
+	rc := newTestConn()
+	rc.KeepAliveTimeout = keepAlive
+	rc.QuietKeepAlive = true
+	rc.MaxFrameSize = 1024
+	rc.NetDialContext = faultyDialer(conns)
+	rc.OnPing = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		pings = append(pings, time.Now())
+	}
+	reasons := disconnectReasons(rc)
+	// the background reader processes the pongs
+	rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	// each message takes about 3 keepalive intervals to write
+	(<-conns).writeDelay.Store(int64(time.Millisecond))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := make([]byte, 256*1024)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := rc.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+					t.Errorf("write failed during the flood: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(8 * keepAlive)
+	close(stop)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pings) < 6 {
+		t.Fatalf("got %d pings in %v, want one every %v", len(pings), 8*keepAlive, keepAlive)
+	}
+	for i := 1; i < len(pings); i++ {
+		if gap := pings[i].Sub(pings[i-1]); gap > keepAlive+keepAlive/2 {
+			t.Fatalf("got %v between pings %d and %d, want them on schedule", gap, i-1, i)
+		}
+	}
+	if got := reasons(); len(got) != 0 {
+		t.Fatalf("got disconnect reasons %v during the flood, want none", got)
+	}
+}
@@ -0,0 +1,32 @@
+package recws
+
+func (rc *RecConn) getLazyConnect() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.LazyConnect
+}
+
+// Connect starts connecting a connection dialed with LazyConnect and
+// waits on the first attempt like Dial. It does nothing before Dial or
+// once connecting started.
+func (rc *RecConn) Connect() {
+	if rc.connectLazily() {
+		rc.waitFirstAttempt()
+	}
+}
+
+// connectLazily starts the connect loop on the first use of a connection
+// dialed with LazyConnect, it reports whether the loop was started
+func (rc *RecConn) connectLazily() bool {
+	rc.mu.Lock()
+	pending := rc.lazyPending
+	rc.lazyPending = false
+	rc.mu.Unlock()
+
+	if pending {
+		rc.startConnect(0)
+	}
+
+	return pending
+}
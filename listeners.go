@@ -0,0 +1,157 @@
+package recws
+
+import (
+	"context"
+	"sync"
+)
+
+// DisconnectReason describes why the connection was closed.
+type DisconnectReason int
+
+const (
+	// DisconnectClosed means the connection was closed by the application.
+	DisconnectClosed DisconnectReason = iota
+	// DisconnectNormalClosure means the peer closed the connection normally.
+	DisconnectNormalClosure
+	// DisconnectReadError means a read from the connection failed.
+	DisconnectReadError
+	// DisconnectWriteError means a write to the connection failed.
+	DisconnectWriteError
+	// DisconnectKeepAliveTimeout means no pong was received in time.
+	DisconnectKeepAliveTimeout
+	// DisconnectSubscribeFailed means the subscribe handler did not complete.
+	DisconnectSubscribeFailed
+	// DisconnectReadTimeout means no message was received within the ReadTimeout.
+	DisconnectReadTimeout
+	// DisconnectNotReady means the ReadyCheck failed.
+	DisconnectNotReady
+)
+
+// String returns the name of the reason.
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectClosed:
+		return "closed"
+	case DisconnectNormalClosure:
+		return "normal closure"
+	case DisconnectReadError:
+		return "read error"
+	case DisconnectWriteError:
+		return "write error"
+	case DisconnectKeepAliveTimeout:
+		return "keepalive timeout"
+	case DisconnectSubscribeFailed:
+		return "subscribe failed"
+	case DisconnectReadTimeout:
+		return "read timeout"
+	case DisconnectNotReady:
+		return "not ready"
+	default:
+		return "unknown"
+	}
+}
+
+type connectListener struct {
+	id uint64
+	fn func()
+}
+
+type disconnectListener struct {
+	id uint64
+	fn func(reason DisconnectReason, err error)
+}
+
+// AddConnectListener registers fn to be called after each successful connect.
+// The returned function removes the listener.
+func (rc *RecConn) AddConnectListener(fn func()) (remove func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.listenerID++
+	id := rc.listenerID
+	rc.connectListeners = append(rc.connectListeners, connectListener{id: id, fn: fn})
+
+	return func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		for i, l := range rc.connectListeners {
+			if l.id == id {
+				rc.connectListeners = append(rc.connectListeners[:i:i], rc.connectListeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// WaitReconnected blocks until the next connect completed, including the
+// SubscribeHandler, even if currently connected. Connects completed before
+// the call are not observed, so call it before triggering a reconnect (e.g.
+// with ForceReconnect) from another goroutine, or right after it.
+// It returns the context error if ctx is done first.
+func (rc *RecConn) WaitReconnected(ctx context.Context) error {
+	var (
+		reconnected = make(chan struct{})
+		once        sync.Once
+	)
+	remove := rc.AddConnectListener(func() {
+		once.Do(func() { close(reconnected) })
+	})
+	defer remove()
+
+	select {
+	case <-reconnected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddDisconnectListener registers fn to be called whenever the DisconnectHandler would fire.
+// The returned function removes the listener.
+func (rc *RecConn) AddDisconnectListener(fn func(reason DisconnectReason, err error)) (remove func()) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.listenerID++
+	id := rc.listenerID
+	rc.disconnectListeners = append(rc.disconnectListeners, disconnectListener{id: id, fn: fn})
+
+	return func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		for i, l := range rc.disconnectListeners {
+			if l.id == id {
+				rc.disconnectListeners = append(rc.disconnectListeners[:i:i], rc.disconnectListeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (rc *RecConn) notifyConnect() {
+	rc.mu.RLock()
+	listeners := rc.connectListeners
+	rc.mu.RUnlock()
+
+	for _, l := range listeners {
+		_ = rc.callHandler("connect listener", func() error {
+			l.fn()
+			return nil
+		})
+	}
+}
+
+func (rc *RecConn) notifyDisconnect(reason DisconnectReason, err error) {
+	rc.mu.RLock()
+	listeners := rc.disconnectListeners
+	rc.mu.RUnlock()
+
+	for _, l := range listeners {
+		_ = rc.callHandler("disconnect listener", func() error {
+			l.fn(reason, err)
+			return nil
+		})
+	}
+}
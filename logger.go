@@ -0,0 +1,141 @@
+package recws
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type logHistory struct {
+	entries []string
+	next    int
+	full    bool
+	sync.Mutex
+}
+
+func (h *logHistory) add(size int, entry string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if len(h.entries) != size {
+		h.entries = make([]string, size)
+		h.next = 0
+		h.full = false
+	}
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % size
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *logHistory) get() []string {
+	h.Lock()
+	defer h.Unlock()
+
+	if !h.full {
+		return append([]string(nil), h.entries[:h.next]...)
+	}
+
+	return append(append([]string(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+func (rc *RecConn) getLogHistorySize() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.LogHistorySize
+}
+
+// logf logs the message and records it in the log history
+func (rc *RecConn) logf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	rc.recordLog(msg)
+	log.Print(msg)
+}
+
+// verbosef is like logf, but only logs if NonVerbose is not set.
+// The message is recorded in the log history regardless.
+func (rc *RecConn) verbosef(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	rc.recordLog(msg)
+	if !rc.getNonVerbose() {
+		log.Print(msg)
+	}
+}
+
+func (rc *RecConn) getQuietKeepAlive() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.QuietKeepAlive
+}
+
+// keepAlivef is like verbosef, but is also suppressed by QuietKeepAlive
+func (rc *RecConn) keepAlivef(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	rc.recordLog(msg)
+	if !rc.getNonVerbose() && !rc.getQuietKeepAlive() {
+		log.Print(msg)
+	}
+}
+
+// logName returns the Name of the connection, or the url if not set
+func (rc *RecConn) logName() string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.Name != "" {
+		return rc.Name
+	}
+
+	return rc.url
+}
+
+// dialErrorClass returns a short classification of a dial error for log messages
+func dialErrorClass(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		recordErr  tls.RecordHeaderError
+		certErr    *tls.CertificateVerificationError
+		unknownErr x509.UnknownAuthorityError
+	)
+
+	switch {
+	case errors.Is(err, websocket.ErrBadHandshake):
+		return "handshake"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &unknownErr):
+		return "tls"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+func (rc *RecConn) recordLog(msg string) {
+	if size := rc.getLogHistorySize(); size > 0 {
+		rc.logHistory.add(size, time.Now().Format(time.RFC3339)+" "+msg)
+	}
+}
+
+// RecentLogs returns the most recent log messages, oldest first.
+// Empty unless LogHistorySize is set.
+func (rc *RecConn) RecentLogs() []string {
+	return rc.logHistory.get()
+}
@@ -0,0 +1,25 @@
+package recws
+
+import "time"
+
+// Metrics holds optional callbacks that are invoked on connection events.
+// Nil callbacks are ignored. Every callback receives the Tags of the connection,
+// which must not be modified.
+type Metrics struct {
+	// OnConnect fires after each successful handshake with the time
+	// the handshake took.
+	OnConnect func(handshake time.Duration, tags map[string]string)
+	// OnReaderBlocked fires when the background reader had to wait
+	// for the consumer of Messages, with the time it waited.
+	OnReaderBlocked func(blocked time.Duration, tags map[string]string)
+	// OnWriteQueue fires when a message is added to or removed from
+	// the write queue, with the number of queued messages.
+	OnWriteQueue func(depth int, tags map[string]string)
+}
+
+func (rc *RecConn) getTags() map[string]string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.Tags
+}
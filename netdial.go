@@ -0,0 +1,46 @@
+package recws
+
+import (
+	"context"
+	"net"
+)
+
+// netDialContext returns the func dialing the network connection,
+// nil to use the gorilla default
+func (rc *RecConn) netDialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if preDial := rc.PreDial; preDial != nil {
+		return func(ctx context.Context, _, _ string) (conn net.Conn, err error) {
+			err = rc.callHandler("PreDial", func() (err error) {
+				conn, err = preDial(ctx)
+				return err
+			})
+			return conn, err
+		}
+	}
+	var dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	if netDialContext := rc.NetDialContext; netDialContext != nil {
+		dialContext = func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+			err = rc.callHandler("NetDialContext", func() (err error) {
+				conn, err = netDialContext(ctx, network, addr)
+				return err
+			})
+			return conn, err
+		}
+	} else {
+		if rc.TCPKeepAlive == 0 && rc.DialNetwork == "" {
+			return nil
+		}
+		dialer := &net.Dialer{KeepAlive: rc.TCPKeepAlive}
+		dialContext = dialer.DialContext
+	}
+	if network := rc.DialNetwork; network != "" {
+		return func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialContext(ctx, network, addr)
+		}
+	}
+
+	return dialContext
+}
@@ -0,0 +1,146 @@
+package recws
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWriteQueueFull is returned when a message is written while
+// disconnected and the write queue holds WriteQueueSize messages
+var ErrWriteQueueFull = errors.New("websocket: write queue full")
+
+type writeQueue struct {
+	msgs     []Message
+	flushing bool
+	// reflush is set by a connect while a flush is running
+	reflush bool
+	sync.Mutex
+}
+
+func (rc *RecConn) getWriteQueueSize() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.WriteQueueSize
+}
+
+// WriteQueueLen returns the number of messages waiting in the write queue.
+func (rc *RecConn) WriteQueueLen() int {
+	rc.writeQueue.Lock()
+	defer rc.writeQueue.Unlock()
+
+	return len(rc.writeQueue.msgs)
+}
+
+// WriteQueueCap returns the capacity of the write queue, the WriteQueueSize.
+func (rc *RecConn) WriteQueueCap() int {
+	return rc.getWriteQueueSize()
+}
+
+// enqueueWrite queues the message if the write queue is enabled and the
+// message cannot be written right away: while disconnected, or while
+// earlier messages are waiting, so the order of the writes is kept.
+func (rc *RecConn) enqueueWrite(messageType int, data []byte) (queued bool, err error) {
+	size := rc.getWriteQueueSize()
+	if size <= 0 {
+		return false, nil
+	}
+
+	rc.writeQueue.Lock()
+	if rc.isTransportUp() && !rc.writeQueue.flushing && len(rc.writeQueue.msgs) == 0 {
+		rc.writeQueue.Unlock()
+		return false, nil
+	}
+	if len(rc.writeQueue.msgs) >= size {
+		rc.writeQueue.Unlock()
+		return true, ErrWriteQueueFull
+	}
+	rc.writeQueue.msgs = append(rc.writeQueue.msgs, Message{Type: messageType, Data: data})
+	depth := len(rc.writeQueue.msgs)
+	rc.writeQueue.Unlock()
+
+	rc.writeQueueChanged(depth)
+
+	return true, nil
+}
+
+func (rc *RecConn) getWriteQueueDropOnError() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.WriteQueueDropOnError
+}
+
+// flushWriteQueue writes the queued messages in order after a connect, in its
+// own goroutine. The writes wait for the WriteRateLimit, also with the
+// NonBlockingRateLimit, as the queued messages were already accepted.
+// A message whose write fails is kept at the head of the queue and the flush
+// pauses until the next connect. With WriteQueueDropOnError the failed message
+// is dropped instead, and the flush continues while the connection is up.
+func (rc *RecConn) flushWriteQueue() {
+	rc.writeQueue.Lock()
+	if rc.writeQueue.flushing {
+		// the running flush continues on the new connection
+		rc.writeQueue.reflush = true
+		rc.writeQueue.Unlock()
+		return
+	}
+	rc.writeQueue.flushing = true
+	rc.writeQueue.Unlock()
+
+	for {
+		rc.writeQueue.Lock()
+		if len(rc.writeQueue.msgs) == 0 {
+			rc.writeQueue.flushing = false
+			rc.writeQueue.reflush = false
+			rc.writeQueue.Unlock()
+			return
+		}
+		msg := rc.writeQueue.msgs[0]
+		rc.writeQueue.Unlock()
+
+		if limiter := rc.getWriteLimiter(); limiter != nil {
+			_ = limiter.Wait(context.Background())
+		}
+		err := rc.writeMessageNow(msg.Type, msg.Data)
+		dropOnError := rc.getWriteQueueDropOnError()
+		if err != nil {
+			rc.logf("WriteQueue: flush failed (dropped: %t): %v", dropOnError, err)
+		}
+
+		rc.writeQueue.Lock()
+		removed := err == nil || dropOnError
+		if removed {
+			rc.writeQueue.msgs = rc.writeQueue.msgs[1:]
+		}
+		depth := len(rc.writeQueue.msgs)
+		pause := err != nil && (!dropOnError || !rc.IsConnected())
+		if pause && rc.writeQueue.reflush {
+			// connected again meanwhile
+			rc.writeQueue.reflush = false
+			pause = false
+		}
+		if pause {
+			rc.writeQueue.flushing = false
+		}
+		rc.writeQueue.Unlock()
+
+		if removed {
+			rc.writeQueueChanged(depth)
+		}
+		if pause {
+			return
+		}
+	}
+}
+
+// writeQueueChanged reports the depth of the write queue to the metrics
+func (rc *RecConn) writeQueueChanged(depth int) {
+	if onWriteQueue := rc.getMetrics().OnWriteQueue; onWriteQueue != nil {
+		_ = rc.callHandler("OnWriteQueue", func() error {
+			onWriteQueue(depth, rc.getTags())
+			return nil
+		})
+	}
+}
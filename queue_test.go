@@ -0,0 +1,56 @@
+package recws
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/recws-org/recws/recwstest"
+)
+
+func TestWriteQueueFlushWithNonBlockingRateLimit(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	release := make(chan struct{})
+	rc := newTestConn()
+	rc.WriteQueueSize = 10
+	rc.WriteRateLimit = 20
+	rc.NonBlockingRateLimit = true
+	rc.PreDial = func(ctx context.Context) (net.Conn, error) {
+		// keep the connection down until the messages are queued
+		<-release
+
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+	rc.Dial(srv.WSURL(), nil)
+
+	want := []string{"one", "two", "three"}
+	for _, msg := range want {
+		if err := rc.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := rc.WriteQueueLen(); got != len(want) {
+		t.Fatalf("got %d queued messages, want %d", got, len(want))
+	}
+
+	close(release)
+	waitFor(t, "connect", rc.IsConnected)
+
+	for _, msg := range want {
+		_, data, err := rc.ReadMessageTimeout(5 * time.Second)
+		if err != nil {
+			t.Fatalf("read %s: %v", msg, err)
+		}
+		if string(data) != msg {
+			t.Fatalf("got message %q, want %q", data, msg)
+		}
+	}
+	// the flush dequeues a message after its write completed
+	waitFor(t, "an empty write queue", func() bool { return rc.WriteQueueLen() == 0 })
+}
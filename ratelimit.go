@@ -0,0 +1,75 @@
+package recws
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when NonBlockingRateLimit is set
+// and a write exceeds the WriteRateLimit
+var ErrRateLimited = errors.New("websocket: write rate limit exceeded")
+
+// getWriteLimiter returns the write limiter, created on first use.
+// nil if WriteRateLimit is not set.
+func (rc *RecConn) getWriteLimiter() *rate.Limiter {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.WriteRateLimit == 0 {
+		return nil
+	}
+
+	if rc.writeLimiter == nil {
+		burst := rc.WriteRateBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		rc.writeLimiter = rate.NewLimiter(rc.WriteRateLimit, burst)
+	}
+
+	return rc.writeLimiter
+}
+
+func (rc *RecConn) getNonBlockingRateLimit() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.NonBlockingRateLimit
+}
+
+// waitWriteLimit waits until the WriteRateLimit allows a write,
+// or the ctx is done.
+func (rc *RecConn) waitWriteLimit(ctx context.Context) error {
+	limiter := rc.getWriteLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	if rc.getNonBlockingRateLimit() {
+		if !limiter.Allow() {
+			return ErrRateLimited
+		}
+		return nil
+	}
+
+	return limiter.Wait(ctx)
+}
+
+// waitReconnectLimit waits until the shared ReconnectLimiter allows
+// a dial attempt, or the connection is closed.
+func (rc *RecConn) waitReconnectLimit() {
+	rc.mu.RLock()
+	limiter := rc.ReconnectLimiter
+	rc.mu.RUnlock()
+
+	if limiter == nil {
+		return
+	}
+
+	ctx, cancel := rc.closedContext()
+	defer cancel()
+
+	_ = limiter.Wait(ctx)
+}
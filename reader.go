@@ -0,0 +1,210 @@
+package recws
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a message delivered by the background reader.
+type Message struct {
+	Type int
+	Data []byte
+}
+
+type backgroundReader struct {
+	messages    chan Message
+	errors      chan error
+	blockedTime atomic.Int64
+	waiters     []*requestWaiter
+	waitersMu   sync.Mutex
+}
+
+// Messages returns the channel of messages read by the background reader.
+// The reader is started on the first call of Messages or Errors and keeps
+// reading across reconnects.
+//
+// When the channel buffer (MessageBufferSize) is full, the reader stops reading
+// from the socket until the consumer catches up, so TCP flow control slows the
+// server down instead of buffering without limit. A persistently slow consumer
+// can therefore cause the server to time out and disconnect.
+//
+// The channel is closed once the reader stopped after Shutdown, so a closed
+// channel signals that no more messages are delivered. Messages buffered
+// before are still delivered, as long as the channel is drained, while a
+// message waiting for a full buffer is dropped on Shutdown.
+func (rc *RecConn) Messages() <-chan Message {
+	return rc.getBackgroundReader().messages
+}
+
+// Errors returns the channel of read errors from the background reader.
+// Errors are dropped if the channel is not drained.
+// The channel is closed together with the Messages channel.
+func (rc *RecConn) Errors() <-chan error {
+	return rc.getBackgroundReader().errors
+}
+
+// ReaderBlockedDuration returns the total time the background reader spent
+// waiting for the consumer of Messages, 0 if the reader was not started.
+func (rc *RecConn) ReaderBlockedDuration() time.Duration {
+	rc.mu.RLock()
+	r := rc.reader
+	rc.mu.RUnlock()
+
+	if r == nil {
+		return 0
+	}
+
+	return time.Duration(r.blockedTime.Load())
+}
+
+func (rc *RecConn) getMessageBufferSize() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.MessageBufferSize
+}
+
+func (rc *RecConn) getMessageFilter() func(messageType int) bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.MessageFilter
+}
+
+// filterMessage reports whether the message passes the MessageFilter,
+// a message is dropped if the filter panics
+func (rc *RecConn) filterMessage(messageType int) bool {
+	filter := rc.getMessageFilter()
+	if filter == nil {
+		return true
+	}
+
+	var deliver bool
+	_ = rc.callHandler("MessageFilter", func() error {
+		deliver = filter(messageType)
+		return nil
+	})
+
+	return deliver
+}
+
+// getShutdownCh returns the channel that is closed on Shutdown
+func (rc *RecConn) getShutdownCh() <-chan struct{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.shutdownCh == nil {
+		rc.shutdownCh = make(chan struct{})
+	}
+
+	return rc.shutdownCh
+}
+
+func (rc *RecConn) getIsShutdown() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.isShutdown
+}
+
+// shutdown stops the background reader and marks the connection as shut down
+// for good, only the first call has an effect
+func (rc *RecConn) shutdown() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.shutdownCh == nil {
+		rc.shutdownCh = make(chan struct{})
+	}
+	if !rc.isShutdown {
+		rc.isShutdown = true
+		close(rc.shutdownCh)
+	}
+}
+
+func (rc *RecConn) getBackgroundReader() *backgroundReader {
+	rc.readerOnce.Do(func() {
+		r := &backgroundReader{
+			messages: make(chan Message, rc.getMessageBufferSize()),
+			errors:   make(chan error, 1),
+		}
+
+		rc.mu.Lock()
+		rc.reader = r
+		rc.mu.Unlock()
+
+		go rc.readLoop(r)
+	})
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.reader
+}
+
+func (rc *RecConn) readLoop(r *backgroundReader) {
+	defer close(r.errors)
+	defer close(r.messages)
+
+	rc.connectLazily()
+
+	done := rc.getShutdownCh()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if !rc.IsConnected() {
+			select {
+			case <-rc.Connected():
+			case <-done:
+				return
+			}
+		}
+
+		messageType, data, err := rc.ReadMessage()
+		if errors.Is(err, ErrNotConnected) || errors.Is(err, ErrClosedByServer) {
+			// disconnected while reading, wait for the next connect
+			continue
+		}
+		if err != nil {
+			select {
+			case r.errors <- err:
+			default:
+			}
+			continue
+		}
+
+		if r.deliverResponse(rc, data) {
+			continue
+		}
+
+		if !rc.filterMessage(messageType) {
+			continue
+		}
+
+		msg := Message{Type: messageType, Data: data}
+		select {
+		case r.messages <- msg:
+		default:
+			start := time.Now()
+			select {
+			case r.messages <- msg:
+			case <-done:
+				return
+			}
+			blocked := time.Since(start)
+			r.blockedTime.Add(int64(blocked))
+			if onReaderBlocked := rc.getMetrics().OnReaderBlocked; onReaderBlocked != nil {
+				_ = rc.callHandler("OnReaderBlocked", func() error {
+					onReaderBlocked(blocked, rc.getTags())
+					return nil
+				})
+			}
+		}
+	}
+}
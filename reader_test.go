@@ -0,0 +1,169 @@
+package recws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/recws-org/recws/recwstest"
+)
+
+// awaitClosed drains the channel and fails the test if it is not closed in time
+func awaitClosed[T any](t *testing.T, name string, ch <-chan T) (received int) {
+	t.Helper()
+
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return received
+			}
+			received++
+		case <-timer.C:
+			t.Fatalf("%s channel not closed after the ctx was canceled", name)
+		}
+	}
+}
+
+func TestMessagesClosedOnCancel(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := newTestConn()
+	if err := rc.DialContext(ctx, srv.WSURL(), nil); err != nil {
+		t.Fatal(err)
+	}
+	messages, errs := rc.Messages(), rc.Errors()
+	waitFor(t, "connect", rc.IsConnected)
+
+	const n = 5
+	for i := 0; i < n; i++ {
+		if err := rc.WriteString("hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-messages:
+			if string(msg.Data) != "hello" {
+				t.Fatalf("got message %q, want hello", msg.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d messages, want %d", i, n)
+		}
+	}
+
+	cancel()
+
+	if extra := awaitClosed(t, "Messages", messages); extra != 0 {
+		t.Fatalf("got %d messages after the cancel, want 0", extra)
+	}
+	awaitClosed(t, "Errors", errs)
+}
+
+func TestMessagesClosedOnCancelWithoutConsumer(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rc := newTestConn()
+	if err := rc.DialContext(ctx, srv.WSURL(), nil); err != nil {
+		t.Fatal(err)
+	}
+	messages, errs := rc.Messages(), rc.Errors()
+	waitFor(t, "connect", rc.IsConnected)
+
+	// nobody receives, so the reader blocks on delivering the first message
+	for i := 0; i < 3; i++ {
+		if err := rc.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the reader to block", func() bool {
+		return rc.LastMessageAt() != (time.Time{})
+	})
+
+	cancel()
+	waitFor(t, "shutdown", func() bool { return !rc.IsConnected() })
+
+	// the reader stops without delivering the pending message
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("got a message after the cancel, want the Messages channel closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Messages channel not closed after the ctx was canceled")
+	}
+	awaitClosed(t, "Errors", errs)
+}
+
+func TestReaderBlockedDurationDoesNotStartReader(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	rc := newTestConn()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	if got := rc.ReaderBlockedDuration(); got != 0 {
+		t.Fatalf("ReaderBlockedDuration = %v, want 0", got)
+	}
+
+	// the echo reaches ReadMessage, not a background reader
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := rc.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("ReadMessage = %q, want hello", data)
+	}
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	if rc.reader != nil {
+		t.Fatal("ReaderBlockedDuration started the background reader")
+	}
+}
+
+func TestReadMessageAfterCloseDuringRead(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	rc := newTestConn()
+	rc.Dial(srv.WSURL(), nil)
+	waitFor(t, "connect", rc.IsConnected)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := rc.ReadMessage()
+		errs <- err
+	}()
+	waitFor(t, "the read", func() bool { return rc.readers.Load() > 0 })
+	rc.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrNotConnected) {
+			t.Fatalf("ReadMessage = %v, want ErrNotConnected", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMessage did not return after Close")
+	}
+}
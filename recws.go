@@ -3,23 +3,48 @@
 package recws
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
+	"golang.org/x/time/rate"
 )
 
 // ErrNotConnected is returned when the application read/writes
-// a message and the connection is closed
+// a message and the connection is closed.
+//
+// Other errors returned by the read/write helpers wrap the underlying
+// error, so errors.Is and errors.As can be used to inspect it, for example
+// to match a *websocket.CloseError or a net.Error.
 var ErrNotConnected = errors.New("websocket: not connected")
 
+// ErrClosedByServer is returned by the read helpers when the server closed
+// the connection with CloseNormalClosure. It wraps the *websocket.CloseError.
+var ErrClosedByServer = errors.New("websocket: closed by server")
+
+// ErrSubscribeTimeout is passed to the disconnect listeners when the
+// subscribe handler did not complete within the SubscribeTimeout
+var ErrSubscribeTimeout = errors.New("websocket: subscribe handler timed out")
+
+// ErrAlreadyDialed is returned when Dial is called more than once
+// on the same connection
+var ErrAlreadyDialed = errors.New("websocket: already dialed")
+
 // The RecConn type represents a Reconnecting WebSocket connection.
 type RecConn struct {
 	// RecIntvlMin specifies the initial reconnecting interval,
@@ -31,435 +56,2173 @@ type RecConn struct {
 	// RecIntvlFactor specifies the rate of increase of the reconnection
 	// interval, default to 1.5
 	RecIntvlFactor float64
+	// BackoffDecay makes a successful connect reduce the reconnecting interval
+	// by one step of the backoff instead of resetting it to RecIntvlMin,
+	// so a flapping connection needs several stable connects to reconnect fast
+	BackoffDecay bool
+	// JitterFraction bounds the random spread of the reconnecting interval
+	// to ±JitterFraction of the interval (0..1), instead of the full
+	// jitter of the backoff, default to full jitter if 0
+	JitterFraction float64
+	// MinReconnectGap specifies the minimum time between consecutive dial
+	// attempts, also across reconnects, so a server dropping each connection
+	// right away cannot cause a tight loop, disabled if 0
+	MinReconnectGap time.Duration
+	// ReconnectLimiter is waited on before each dial attempt. Share it between
+	// connections to cap their total rate of dial attempts, for example
+	// when a shared backend restarts
+	ReconnectLimiter *rate.Limiter
+	// FirstReconnectDelay specifies the delay before the first attempt
+	// of a reconnect, the initial Dial attempts immediately, disabled if 0
+	FirstReconnectDelay time.Duration
+	// ReconnectSchedule specifies a fixed list of reconnecting intervals used
+	// instead of the backoff, if not empty. The last interval is repeated
+	// once the list is exhausted, and the schedule restarts after a successful connect.
+	ReconnectSchedule []time.Duration
+	// MaxReconnectAttempts specifies the number of failed attempts after
+	// which the connect loop gives up, unlimited if 0
+	MaxReconnectAttempts int
+	// FailFastStatusCodes specifies handshake response status codes
+	// (e.g. 401, 403) after which the connect loop gives up right away
+	FailFastStatusCodes []int
+	// GiveUpHandler fires when the connect loop gives up
+	// after the MaxReconnectAttempts or on a FailFastStatusCodes status
+	GiveUpHandler func(err error)
 	// HandshakeTimeout specifies the duration for the handshake to complete,
 	// default to 2 seconds
 	HandshakeTimeout time.Duration
+	// FollowRedirects makes the next dial attempt use the Location of a redirect (3xx)
+	// returned by the handshake, instead of retrying the same url.
+	FollowRedirects bool
+	// MaxRedirects specifies the maximum number of redirects followed
+	// in a row, default to 10
+	MaxRedirects int
+	// SkipDialWait makes Dial return right after starting to connect, instead of
+	// waiting for the HandshakeTimeout. Use IsConnected or Connected to synchronize.
+	SkipDialWait bool
+	// LazyConnect makes Dial only configure the connection. Connecting starts
+	// with the first read or write (e.g. ReadMessage, WriteJSON or Messages),
+	// which returns ErrNotConnected until connected, or with Connect
+	LazyConnect bool
+	// WriteWait specifies the deadline of each write, so a stuck write fails
+	// and triggers a reconnect instead of blocking forever,
+	// default to 10 seconds, disabled if negative
+	WriteWait time.Duration
+	// TCPKeepAlive specifies the interval of the TCP keepalive probes
+	// on the underlying connection, default to the net.Dialer default
+	// of 15 seconds, disabled if negative
+	TCPKeepAlive time.Duration
+	// DialNetwork specifies the network of the underlying connection, one of
+	// "tcp", "tcp4" or "tcp6" to force IPv4 or IPv6, default to "tcp"
+	DialNetwork string
+	// ConfigureDialer is called with a copy of the dialer right before each
+	// dial attempt, after the config is applied, to adjust it for the attempt
+	ConfigureDialer func(d *websocket.Dialer, attempt int)
+	// NetDialContext specifies the dial func for the underlying network
+	// connection, overriding TCPKeepAlive. It is called with DialNetwork
+	// as the network if set
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// PreDial supplies an established connection (e.g. an SSH-forwarded socket)
+	// that the handshake runs over on each dial attempt, overriding NetDialContext
+	PreDial func(ctx context.Context) (net.Conn, error)
+	// DialDeadline bounds each dial attempt, including DNS, TCP and TLS.
+	// The reconnect interval is only waited after a failed attempt, so on
+	// an unreachable host the retry rate is at most one attempt per
+	// DialDeadline + interval. Set it below RecIntvlMin to keep the backoff
+	// schedule dominant. Disabled if 0, then only HandshakeTimeout applies.
+	DialDeadline time.Duration
 	// Proxy specifies the proxy function for the dialer
 	// defaults to ProxyFromEnvironment
 	Proxy func(*http.Request) (*url.URL, error)
-	// Client TLS config to use on reconnect
+	// ProxyAuth specifies the credentials for an HTTP CONNECT proxy,
+	// sent as Proxy-Authorization basic auth. Create it with url.UserPassword.
+	// Userinfo in the url returned by Proxy takes precedence.
+	ProxyAuth *url.Userinfo
+	// Client TLS config to use on reconnect.
+	// The current config is read before every dial, so settings such as
+	// KeyLogWriter apply to each reconnect, not only the first connection.
 	TLSClientConfig *tls.Config
-	// SubscribeHandler fires after the connection successfully establish.
+	// InsecureSkipVerify disables the verification of the server certificate,
+	// on top of the TLSClientConfig. Only use it for local development,
+	// a warning is logged once when it is applied.
+	InsecureSkipVerify bool
+	// Origin specifies the Origin header sent on every dial attempt,
+	// overriding the Origin of the request header
+	Origin string
+	// NextHeaders provides the request header for each dial attempt instead of
+	// the header passed to Dial, if set. prevResp is the handshake response of the
+	// previous attempt, nil on the first attempt, and attempt starts at 1.
+	// An error aborts the attempt, which is retried after the reconnecting interval.
+	NextHeaders func(prevResp *http.Response, attempt int) (http.Header, error)
+	// ResumeToken provides the session resumption token sent on reconnects,
+	// defaults to the last token captured by CaptureResumeToken
+	ResumeToken func() string
+	// ResumeTokenHeader specifies the request header carrying the resume token,
+	// default to X-Resume-Token
+	ResumeTokenHeader string
+	// CaptureResumeToken extracts the server issued resume token
+	// from the handshake response after each successful connect
+	CaptureResumeToken func(resp *http.Response) string
+	// SubscribeHandler fires after the connection successfully establish,
+	// before it is marked as connected, see ReadyCheck.
+	// Use SetSubscribeHandler to change it after Dial.
 	SubscribeHandler func() error
+	// SubscribeContextHandler is like SubscribeHandler, but its ctx is canceled
+	// after the SubscribeTimeout. It is used instead of SubscribeHandler if set.
+	SubscribeContextHandler func(ctx context.Context) error
+	// BaseContext provides the values (e.g. trace IDs) of the ctx passed to
+	// SubscribeContextHandler and DisconnectContextHandler. Its cancellation
+	// does not apply to the handlers, default to context.Background()
+	BaseContext context.Context
+	// ReadyCheck runs after the SubscribeHandler, for an application level
+	// exchange (e.g. hello/ack) before the connection is usable. The connection
+	// is only marked as connected (IsConnected, Connected, the connect listeners
+	// and the Events) once it succeeds. The read and write helpers already work
+	// for the check meanwhile, and the background reader waits, so the check
+	// reads its own reply. On failure the connection is closed and reconnected
+	// with the backoff.
+	ReadyCheck func() error
+	// HoldWritesUntilSubscribed holds the writes while the SubscribeContextHandler
+	// runs on a new connection, so application writes land after the
+	// subscription. Writes with its ctx (WriteMessageContext, WriteJSONContext)
+	// are not held. It requires a SubscribeContextHandler and no ReadyCheck,
+	// as the writes of the SubscribeHandler and the ReadyCheck would wait on
+	// the hold, see Validate.
+	HoldWritesUntilSubscribed bool
+	// SubscribeDelay specifies the maximum of a random delay before the
+	// SubscribeHandler runs on a new connection, spreading the subscriptions
+	// of many clients reconnecting at once, disabled if 0
+	SubscribeDelay time.Duration
+	// SubscribeTimeout specifies the duration for the subscribe handler to complete.
+	// On timeout the connection is closed and reconnected, disabled if 0
+	SubscribeTimeout time.Duration
+	// DisconnectHandler fires after the connection is closed,
+	// except when closed with CloseSilently or Shutdown.
+	// It always completes before the next dial attempt, and never runs
+	// concurrently with the SubscribeHandler or the connect listeners: a
+	// disconnect during those is handled after they complete.
+	// Use SetDisconnectHandler to change it after Dial.
+	DisconnectHandler func()
+	// DisconnectContextHandler is like DisconnectHandler, with the values of
+	// the BaseContext. It fires after the DisconnectHandler if both are set.
+	DisconnectContextHandler func(ctx context.Context)
+	// AsyncDisconnectHandler runs the DisconnectHandler in its own goroutine,
+	// so a slow handler does not delay the reconnect. The handler then may run
+	// concurrently with the next dial attempt, the SubscribeHandler and the
+	// connect listeners, and with the handler of a later disconnect.
+	AsyncDisconnectHandler bool
+	// CloseFrameHandler fires when a close frame is received, with its code
+	// and the payload of the reason, before the connection is closed or
+	// reconnected
+	CloseFrameHandler func(code int, payload []byte)
+	// PongHandler fires when a pong is received, after the keepalive bookkeeping.
+	// An error returned by the handler fails the current read, which triggers
+	// a reconnect, unless PongHandlerSwallowError is set.
+	// Use SetPongHandler to change it after Dial.
+	PongHandler func(appData string) error
+	// PongHandlerSwallowError logs errors returned by the PongHandler
+	// instead of failing the read.
+	PongHandlerSwallowError bool
+	// OnRecover fires on the first successful connect after one or more
+	// failed attempts, with the downtime since LastDisconnectedAt and the
+	// number of failed attempts.
+	OnRecover func(downtime time.Duration, attempts int)
+	// HandlerPanicHandler fires when a handler, listener or other callback
+	// (e.g. the Metrics, MessageFilter or Proxy) panics, which specifies the
+	// panicking callback. The panic is logged if not set.
+	// A panicking handler fails like one returning an error, a panicking
+	// SubscribeHandler or ReadyCheck closes and reconnects the connection.
+	HandlerPanicHandler func(which string, recovered interface{})
+	// MakeBeforeBreak makes ForceReconnect establish and subscribe the new
+	// connection before closing the current one, so both are briefly open.
+	// The current connection is kept until the new one is subscribed,
+	// the ctx writes (WriteMessageContext, WriteJSONContext) of the
+	// SubscribeContextHandler go to the new one. Requires no SubscribeHandler
+	// and no ReadyCheck, see Validate.
+	MakeBeforeBreak bool
+	// GoingAwayReconnectDelay specifies an extra delay before reconnecting
+	// when the server closes the connection with CloseGoingAway (1001),
+	// giving a restarting server time to come back. Disabled if 0
+	GoingAwayReconnectDelay time.Duration
+	// ReconnectOnNormalClosure reconnects when the server closes the connection
+	// with CloseNormalClosure (1000), which otherwise closes it for good,
+	// for servers closing normally on rotation
+	ReconnectOnNormalClosure bool
 	// KeepAliveTimeout is an interval for sending ping/pong messages
 	// disabled if 0
 	KeepAliveTimeout time.Duration
+	// ReadTimeout specifies a rolling read deadline for ReadMessage and the
+	// background reader. If no message arrives within it, the connection is
+	// reconnected and ErrNotConnected is returned instead of the deadline error.
+	// Overrides the read deadline of ReadDeadlineOnPong, disabled if 0
+	ReadTimeout time.Duration
+	// ReadDeadlineOnPong sets a read deadline of PongTimeout after each connect,
+	// which is extended whenever a pong is received. A read on a connection
+	// without pongs then fails and triggers a reconnect. Pair it with the
+	// KeepAliveTimeout to send pings within the PongTimeout.
+	ReadDeadlineOnPong bool
+	// PongTimeout specifies the read deadline for ReadDeadlineOnPong,
+	// default to 60 seconds
+	PongTimeout time.Duration
+	// OnPing fires after each ping sent by the keepalive
+	OnPing func()
+	// QuietKeepAlive suppress keepalive messages only.
+	QuietKeepAlive bool
+	// KeepAlivePingJSON is sent as a JSON data message instead of
+	// a ping control frame by the keepalive, if set.
+	// Pongs are still detected by the pong handler.
+	KeepAlivePingJSON interface{}
+	// Name identifies the connection in log messages instead of the url
+	Name string
 	// NonVerbose suppress connecting/reconnecting messages.
 	NonVerbose bool
+	// DialErrorHistorySize specifies how many recent dial errors are kept
+	// for DialErrorHistory, disabled if 0
+	DialErrorHistorySize int
+	// LogHistorySize specifies how many recent log messages are kept
+	// for RecentLogs, disabled if 0
+	LogHistorySize int
 	// Compression enables per-message compression as defined in https://datatracker.ietf.org/doc/html/rfc7692
 	Compression bool
-
-	isConnected bool
-	mu          sync.RWMutex
-	url         string
-	reqHeader   http.Header
-	httpResp    *http.Response
-	dialErr     error
-	dialer      *websocket.Dialer
+	// WriteRateLimit specifies the maximum number of messages written
+	// per second by WriteMessage and WriteJSON, unlimited if 0
+	WriteRateLimit rate.Limit
+	// WriteRateBurst specifies the burst size of the WriteRateLimit, default to 1
+	WriteRateBurst int
+	// NonBlockingRateLimit makes writes over the WriteRateLimit return
+	// ErrRateLimited instead of waiting
+	NonBlockingRateLimit bool
+	// WriteBatchWindow specifies how long WriteBatched collects messages
+	// before writing them as a single message, disabled if 0
+	WriteBatchWindow time.Duration
+	// WriteBatchMaxSize specifies the size in bytes after which the
+	// write batch is written before the window elapsed, unlimited if 0
+	WriteBatchMaxSize int
+	// Concat joins the messages of a write batch, default to concatenating them.
+	// The batch is dropped and the write fails if it panics.
+	Concat func(msgs [][]byte) []byte
+	// WriteQueueSize specifies how many messages written with WriteMessage
+	// while disconnected are queued and written in order after the next
+	// connect, disabled if 0. The order of the writes is kept (FIFO): while
+	// messages are queued, further writes are queued behind them. The queue is
+	// flushed in the background after the connect, at the WriteRateLimit.
+	WriteQueueSize int
+	// WriteQueueDropOnError drops a queued message whose write fails while
+	// flushing the queue, for best-effort delivery. By default the message is
+	// kept at the head of the queue and retried after the next connect, for
+	// at-least-once delivery.
+	WriteQueueDropOnError bool
+	// MaxFrameSize specifies the maximum payload size of a written frame,
+	// larger messages of WriteMessage are split into continuation frames.
+	// Set it before Dial, as the frame size is fixed on connect. Disabled if 0
+	MaxFrameSize int
+	// EventBufferSize specifies the channel buffer of Events, default to 64
+	EventBufferSize int
+	// MessageBufferSize specifies the buffer size of the Messages channel
+	MessageBufferSize int
+	// MessageFilter reports whether the background reader delivers a message
+	// of the messageType to the Messages channel, all messages are delivered if nil.
+	// A message is dropped if the filter panics.
+	MessageFilter func(messageType int) bool
+	// Clock provides the time for the reconnect and keepalive timing,
+	// default to the real clock. It must be set before Dial.
+	Clock Clock
+	// Metrics holds optional callbacks for connection metrics.
+	Metrics Metrics
+	// Tags are passed to every Metrics callback, for example as metric labels
+	Tags map[string]string
+
+	isConnected        bool
+	transportUp        bool
+	isReconnecting     atomic.Bool
+	reconnectRequested atomic.Bool
+	readers            atomic.Int32
+	generation         uint64
+	live               atomic.Pointer[liveConn]
+	lastMessageAt      atomic.Int64
+	isClosed           bool
+	closedCh           chan struct{}
+	connectDone        chan struct{}
+	connectedCh        chan struct{}
+	connClosedCh       chan struct{}
+	dialed             bool
+	lazyPending        bool
+	mu                 sync.RWMutex
+	writeMu            sync.Mutex
+	url                string
+	parsedURL          *url.URL
+	urls               []*url.URL
+	reqHeader          http.Header
+	httpResp           *http.Response
+	dialErr            error
+	dialer             *websocket.Dialer
+	logHistory         logHistory
+	readerOnce         sync.Once
+	reader             *backgroundReader
+	shutdownCh         chan struct{}
+	isShutdown         bool
+	writeLimiter       *rate.Limiter
+	counters           byteCounters
+	writeQueue         writeQueue
+	events             chan Event
+	writeHold          chan struct{}
+	pendingRead        *pendingRead
+	dialErrors         []*DialError
+	writeCompression   *bool
+	insecureWarning    sync.Once
+	writeBatch         writeBatch
+	backoff            *backoff.Backoff
+	nextItvl           time.Duration
+	backoffLevel       int
+	keepAliveResponse  *keepAliveResponse
+
+	handshakeDuration  time.Duration
+	lastPongRTT        time.Duration
+	lastDialAt         time.Time
+	compressionEnabled bool
+	extensions         []string
+	connectCount       int
+	reconnectCount     int
+	lastConnectedAt    time.Time
+	lastDisconnectedAt time.Time
+	lastCloseCode      int
+	resumeToken        string
+
+	listenerID          uint64
+	connectListeners    []connectListener
+	disconnectListeners []disconnectListener
+	giveUpWaiters       []func(err error)
+	transition          transition
 
 	*websocket.Conn
 }
 
 // CloseAndReconnect will try to reconnect.
+// It also reconnects a connection closed by Close, but not after Shutdown.
 func (rc *RecConn) CloseAndReconnect() {
-	rc.Close()
-	go rc.connect()
-}
-
-// setIsConnected sets state for isConnected
-func (rc *RecConn) setIsConnected(state bool) {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+	if rc.getIsShutdown() {
+		return
+	}
 
-	rc.isConnected = state
+	rc.setIsClosed(false)
+	rc.closeAndReconnect(DisconnectClosed, nil)
 }
 
-func (rc *RecConn) getConn() *websocket.Conn {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
-
-	return rc.Conn
-}
+// ForceReconnect proactively replaces the connection with a new one.
+// With MakeBeforeBreak the new connection is established and subscribed
+// before the current one is closed, otherwise it is like CloseAndReconnect.
+func (rc *RecConn) ForceReconnect() {
+	if !rc.getMakeBeforeBreak() || !rc.IsConnected() {
+		rc.CloseAndReconnect()
+		return
+	}
 
-// Close closes the underlying network connection without
-// sending or waiting for a close frame.
-func (rc *RecConn) Close() {
-	if rc.getConn() != nil {
-		rc.mu.Lock()
-		rc.Conn.Close()
-		rc.mu.Unlock()
+	if !rc.beginReconnecting() {
+		return
 	}
 
-	rc.setIsConnected(false)
-}
+	done := make(chan struct{})
+	rc.mu.Lock()
+	rc.connectDone = done
+	rc.mu.Unlock()
 
-// Shutdown gracefully closes the connection by sending the websocket.CloseMessage.
-// The writeWait param defines the duration before the deadline of the write operation is hit.
-func (rc *RecConn) Shutdown(writeWait time.Duration) {
-	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-	err := rc.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
-	if err != nil && err != websocket.ErrCloseSent {
-		// If close message could not be sent, then close without the handshake.
-		log.Printf("Shutdown: %v", err)
-		rc.Close()
-	}
+	go func() {
+		defer close(done)
+		defer rc.endReconnecting()
+
+		rc.makeBeforeBreak()
+	}()
 }
 
-// ReadMessage is a helper method for getting a reader
-// using NextReader and reading from that reader to a buffer.
-//
-// If the connection is closed ErrNotConnected is returned
-func (rc *RecConn) ReadMessage() (messageType int, message []byte, err error) {
-	err = ErrNotConnected
-	if rc.IsConnected() {
-		messageType, message, err = rc.Conn.ReadMessage()
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			rc.Close()
-			return messageType, message, nil
-		}
-		if err != nil {
-			rc.CloseAndReconnect()
-		}
+// makeBeforeBreak dials and subscribes a new connection and then closes the old one.
+// The new connection is installed once it is prepared, if the dial or the
+// subscription fails, the old connection is kept.
+func (rc *RecConn) makeBeforeBreak() {
+	wsConn, httpResp, handshakeDuration, err := rc.dial(nil, 1)
+	if err != nil {
+		// keep the current connection
+		rc.verbosef("Dial: make before break failed: %v", err)
+		return
 	}
 
-	return
-}
+	rc.verbosef("Dial: connection was successfully established with %s", rc.GetURL())
 
-// WriteMessage is a helper method for getting a writer using NextWriter,
-// writing the message and closing the writer.
-//
-// If the connection is closed ErrNotConnected is returned
-func (rc *RecConn) WriteMessage(messageType int, data []byte) error {
-	err := ErrNotConnected
-	if rc.IsConnected() {
-		rc.mu.Lock()
-		err = rc.Conn.WriteMessage(messageType, data)
-		rc.mu.Unlock()
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			rc.Close()
-			return nil
-		}
-		if err != nil {
-			rc.CloseAndReconnect()
-		}
+	rc.beginConnecting()
+	if err := rc.prepareConn(wsConn); err != nil {
+		// keep the current connection
+		rc.verbosef("Dial: make before break failed: %v", err)
+		wsConn.Close()
+		rc.endConnecting()
+		return
 	}
 
-	return err
-}
-
-// WriteJSON writes the JSON encoding of v to the connection.
-//
-// See the documentation for encoding/json Marshal for details about the
-// conversion of Go values to JSON.
-//
-// If the connection is closed ErrNotConnected is returned
-func (rc *RecConn) WriteJSON(v interface{}) error {
-	err := ErrNotConnected
-	if rc.IsConnected() {
-		rc.mu.Lock()
-		err = rc.Conn.WriteJSON(v)
+	rc.mu.Lock()
+	if rc.isClosed {
 		rc.mu.Unlock()
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			rc.Close()
-			return nil
-		}
-		if err != nil {
-			rc.CloseAndReconnect()
-		}
+		wsConn.Close()
+		rc.endConnecting()
+		return
 	}
+	oldConn := rc.Conn
+	rc.dialErr = nil
+	rc.setConn(wsConn, httpResp, handshakeDuration)
+	rc.mu.Unlock()
 
-	return err
-}
-
-// ReadJSON reads the next JSON-encoded message from the connection and stores
-// it in the value pointed to by v.
-//
-// See the documentation for the encoding/json Unmarshal function for details
-// about the conversion of JSON to a Go value.
-//
-// If the connection is closed ErrNotConnected is returned
-func (rc *RecConn) ReadJSON(v interface{}) error {
-	err := ErrNotConnected
-	if rc.IsConnected() {
-		err = rc.Conn.ReadJSON(v)
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
-			rc.Close()
-			return nil
-		}
-		if err != nil {
-			rc.CloseAndReconnect()
-		}
+	if oldConn != nil {
+		// the read error of the retired connection does not reach Errors
+		oldConn.Close()
 	}
 
-	return err
+	rc.connected(httpResp, handshakeDuration)
+	rc.endConnecting()
 }
 
-func (rc *RecConn) setURL(url string) {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+// getMakeBeforeBreak reports whether MakeBeforeBreak applies, not with a
+// SubscribeHandler or ReadyCheck set after Dial
+func (rc *RecConn) getMakeBeforeBreak() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
 
-	rc.url = url
+	return rc.MakeBeforeBreak && rc.SubscribeHandler == nil && rc.ReadyCheck == nil
 }
 
-func (rc *RecConn) setReqHeader(reqHeader http.Header) {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
-
-	rc.reqHeader = reqHeader
+// closeAndReconnect closes the connection and reconnects,
+// unless the connection was closed by the application.
+func (rc *RecConn) closeAndReconnect(reason DisconnectReason, err error) {
+	rc.closeAndReconnectGeneration(anyGeneration, reason, err)
 }
 
-// parseURL parses current url
-func (rc *RecConn) parseURL(urlStr string) (string, error) {
-	if urlStr == "" {
-		return "", errors.New("dial: url cannot be empty")
+// closeAndReconnectGeneration is like closeAndReconnect, but only closes
+// the connection of the generation, if it is still connected
+func (rc *RecConn) closeAndReconnectGeneration(generation uint64, reason DisconnectReason, err error) {
+	if !rc.closeGeneration(generation, true, reason, err) {
+		return
 	}
 
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return "", errors.New("url: " + err.Error())
+	if rc.getIsClosed() {
+		return
 	}
 
-	if u.Scheme != "ws" && u.Scheme != "wss" {
-		return "", errors.New("url: websocket uris must start with ws or wss scheme")
+	if delay := rc.getGoingAwayReconnectDelay(); delay > 0 && websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		rc.verbosef("Dial: server is going away, will reconnect in %v", delay)
+		rc.startConnect(delay)
+		return
 	}
 
-	if u.User != nil {
-		return "", errors.New("url: user name and password are not allowed in websocket URIs")
+	rc.startConnect(0)
+}
+
+// closeNormalClosure closes the connection after a normal closure by the server,
+// reconnecting only with ReconnectOnNormalClosure
+func (rc *RecConn) closeNormalClosure(err error) {
+	rc.mu.RLock()
+	reconnect := rc.ReconnectOnNormalClosure
+	rc.mu.RUnlock()
+
+	if reconnect {
+		rc.closeAndReconnect(DisconnectNormalClosure, err)
+		return
 	}
 
-	return urlStr, nil
+	rc.close(true, DisconnectNormalClosure, err)
 }
 
-func (rc *RecConn) setDefaultRecIntvlMin() {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+func (rc *RecConn) getGoingAwayReconnectDelay() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
 
-	if rc.RecIntvlMin == 0 {
-		rc.RecIntvlMin = 2 * time.Second
-	}
+	return rc.GoingAwayReconnectDelay
 }
 
-func (rc *RecConn) setDefaultRecIntvlMax() {
+// setIsConnected sets state for isConnected
+func (rc *RecConn) setIsConnected(state bool) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
-	if rc.RecIntvlMax == 0 {
-		rc.RecIntvlMax = 30 * time.Second
-	}
+	rc.isConnected = state
+	rc.updateConnectedCh()
 }
 
-func (rc *RecConn) setDefaultRecIntvlFactor() {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+func (rc *RecConn) getConn() *websocket.Conn {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
 
-	if rc.RecIntvlFactor == 0 {
-		rc.RecIntvlFactor = 1.5
-	}
+	return rc.Conn
 }
 
-func (rc *RecConn) setDefaultHandshakeTimeout() {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+// Close closes the underlying network connection without
+// sending or waiting for a close frame.
+// The connection is not reconnected until CloseAndReconnect is called.
+func (rc *RecConn) Close() {
+	rc.setIsClosed(true)
+	rc.close(true, DisconnectClosed, nil)
+}
 
-	if rc.HandshakeTimeout == 0 {
-		rc.HandshakeTimeout = 2 * time.Second
-	}
+// CloseSilently closes the underlying network connection like Close,
+// but without firing the DisconnectHandler.
+func (rc *RecConn) CloseSilently() {
+	rc.setIsClosed(true)
+	rc.close(false, DisconnectClosed, nil)
 }
 
-func (rc *RecConn) setDefaultProxy() {
+func (rc *RecConn) getIsClosed() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.isClosed
+}
+
+func (rc *RecConn) setIsClosed(state bool) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
-	if rc.Proxy == nil {
-		rc.Proxy = http.ProxyFromEnvironment
+	if !state && rc.isShutdown {
+		// Shutdown is terminal
+		return
+	}
+
+	if rc.closedCh == nil {
+		rc.closedCh = make(chan struct{})
+	}
+	if state && !rc.isClosed {
+		close(rc.closedCh)
+	} else if !state && rc.isClosed {
+		rc.closedCh = make(chan struct{})
 	}
+	rc.isClosed = state
 }
 
-func (rc *RecConn) setDefaultDialer(tlsClientConfig *tls.Config, handshakeTimeout time.Duration, compression bool) {
+// getClosedCh returns a channel that is closed once the connection is closed
+func (rc *RecConn) getClosedCh() <-chan struct{} {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
-	rc.dialer = &websocket.Dialer{
-		HandshakeTimeout:  handshakeTimeout,
-		Proxy:             rc.Proxy,
-		TLSClientConfig:   tlsClientConfig,
-		EnableCompression: compression,
+	if rc.closedCh == nil {
+		rc.closedCh = make(chan struct{})
+		if rc.isClosed {
+			close(rc.closedCh)
+		}
 	}
+
+	return rc.closedCh
 }
 
-func (rc *RecConn) getHandshakeTimeout() time.Duration {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+// closedContext returns a ctx that is canceled once the connection is closed
+func (rc *RecConn) closedContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	return rc.HandshakeTimeout
-}
+	closed := rc.getClosedCh()
+	go func() {
+		select {
+		case <-closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-func (rc *RecConn) getTLSClientConfig() *tls.Config {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+	return ctx, cancel
+}
 
-	return rc.TLSClientConfig
+func (rc *RecConn) close(fireHandler bool, reason DisconnectReason, err error) {
+	rc.closeGeneration(anyGeneration, fireHandler, reason, err)
 }
 
-func (rc *RecConn) SetTLSClientConfig(tlsClientConfig *tls.Config) {
+// closeGeneration is like close, but only closes the connection of the generation,
+// if it is still connected. It reports whether the connection was closed.
+func (rc *RecConn) closeGeneration(generation uint64, fireHandler bool, reason DisconnectReason, err error) bool {
 	rc.mu.Lock()
-	defer rc.mu.Unlock()
+	if generation != anyGeneration && (generation != rc.generation || !rc.transportUp) {
+		rc.mu.Unlock()
+		return false
+	}
+	wasConnected := rc.transportUp
+	if rc.Conn != nil {
+		rc.Conn.Close()
+	}
+	if wasConnected {
+		rc.lastDisconnectedAt = rc.getClock().Now()
+		close(rc.connClosedCh)
+	}
+	// the handlers do not fire once Shutdown began
+	fireHandler = fireHandler && !rc.isShutdown
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		rc.lastCloseCode = closeErr.Code
+	}
+	rc.transportUp = false
+	rc.live.Store(nil)
+	rc.isConnected = false
+	rc.updateConnectedCh()
+	rc.mu.Unlock()
 
-	rc.TLSClientConfig = tlsClientConfig
-}
+	if wasConnected {
+		rc.emit(EventDisconnected, 0, err)
+	}
 
-// Dial creates a new client connection.
-// The URL url specifies the host and request URI. Use requestHeader to specify
-// the origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies
-// (Cookie). Use GetHTTPResponse() method for the response.Header to get
-// the selected subprotocol (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
-func (rc *RecConn) Dial(urlStr string, reqHeader http.Header) {
-	urlStr, err := rc.parseURL(urlStr)
-	if err != nil {
-		log.Fatalf("Dial: %v", err)
+	if !fireHandler || !wasConnected {
+		return true
 	}
 
-	// Config
-	rc.setURL(urlStr)
-	rc.setReqHeader(reqHeader)
-	rc.setDefaultRecIntvlMin()
-	rc.setDefaultRecIntvlMax()
-	rc.setDefaultRecIntvlFactor()
-	rc.setDefaultHandshakeTimeout()
-	rc.setDefaultProxy()
-	rc.setDefaultDialer(rc.getTLSClientConfig(), rc.getHandshakeTimeout(), rc.Compression)
+	runDisconnectHandler := func() {
+		if disconnectHandler := rc.getDisconnectHandler(); disconnectHandler != nil {
+			_ = rc.callHandler("DisconnectHandler", func() error {
+				disconnectHandler()
+				return nil
+			})
+		}
+		if disconnectContextHandler := rc.getDisconnectContextHandler(); disconnectContextHandler != nil {
+			_ = rc.callHandler("DisconnectContextHandler", func() error {
+				disconnectContextHandler(rc.handlerContext())
+				return nil
+			})
+		}
+	}
+	async := rc.getAsyncDisconnectHandler()
+	if async {
+		go runDisconnectHandler()
+	}
 
-	// Connect
-	go rc.connect()
+	rc.fireDisconnect(func() {
+		if !async {
+			runDisconnectHandler()
+		}
+		rc.notifyDisconnect(reason, err)
+	})
 
-	// wait on first attempt
-	time.Sleep(rc.getHandshakeTimeout())
+	return true
 }
 
-// GetURL returns current connection url
-func (rc *RecConn) GetURL() string {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+// Shutdown gracefully closes the connection by sending the websocket.CloseMessage
+// and waiting for the close message of the server before closing the connection.
+// The writeWait param defines the duration before the deadline of the write
+// operation is hit, and bounds the wait for the close message of the server.
+// Once Shutdown is called, the connection is not reconnected anymore, also
+// not by CloseAndReconnect or Redial, and the channels of the background
+// reader are closed. Unlike Close, this is terminal.
+//
+// If the connection is closed ErrNotConnected is returned, the connection
+// is shut down nonetheless.
+func (rc *RecConn) Shutdown(writeWait time.Duration) error {
+	rc.setIsClosed(true)
+	rc.shutdown()
+
+	conn := rc.getConn()
+	if conn == nil || !rc.isTransportUp() {
+		rc.close(false, DisconnectClosed, nil)
+		return ErrNotConnected
+	}
 
-	return rc.url
-}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	if err != nil && err != websocket.ErrCloseSent {
+		// If close message could not be sent, then close without the handshake.
+		rc.logf("Shutdown: %v", err)
+		rc.close(false, DisconnectClosed, nil)
+		return fmt.Errorf("recws: shutdown: %w", err)
+	}
 
-func (rc *RecConn) getNonVerbose() bool {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+	rc.awaitCloseMessage(conn, writeWait)
+	rc.close(false, DisconnectClosed, nil)
 
-	return rc.NonVerbose
+	return nil
 }
 
-func (rc *RecConn) getBackoff() *backoff.Backoff {
+// awaitCloseMessage waits up to timeout for the close message of the server.
+// A running read or the background reader sees the close message and closes
+// the connection, otherwise the connection is read until the close message
+// arrives.
+func (rc *RecConn) awaitCloseMessage(conn *websocket.Conn, timeout time.Duration) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
 	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+	hasReader := rc.reader != nil
+	up, closed := rc.transportUp && rc.Conn == conn, rc.connClosedCh
+	rc.mu.RUnlock()
 
-	return &backoff.Backoff{
-		Min:    rc.RecIntvlMin,
-		Max:    rc.RecIntvlMax,
-		Factor: rc.RecIntvlFactor,
-		Jitter: true,
+	if !up {
+		return
 	}
-}
 
-func (rc *RecConn) hasSubscribeHandler() bool {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
-
-	return rc.SubscribeHandler != nil
-}
+	if !hasReader && rc.readers.Load() == 0 {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}
 
-func (rc *RecConn) getKeepAliveTimeout() time.Duration {
-	rc.mu.RLock()
-	defer rc.mu.RUnlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	return rc.KeepAliveTimeout
+	select {
+	case <-closed:
+	case <-timer.C:
+	}
 }
 
-func (rc *RecConn) writeControlPingMessage() error {
-	rc.mu.Lock()
-	defer rc.mu.Unlock()
+// ReadMessage is a helper method for getting a reader
+// using NextReader and reading from that reader to a buffer.
+//
+// The returned error is:
+//   - nil when a message was read.
+//   - ErrNotConnected when the connection is closed, also by Close or
+//     Shutdown during the read, not yet connected or was dropped because
+//     of the ReadTimeout; the connection is reconnecting unless Close or
+//     Shutdown was called.
+//   - ErrClosedByServer when the server closed the connection with
+//     CloseNormalClosure; the connection is reconnecting only if
+//     ReconnectOnNormalClosure is set, otherwise it stays closed and later
+//     reads return ErrNotConnected.
+//   - any other read error, wrapped; the connection is reconnecting.
+func (rc *RecConn) ReadMessage() (messageType int, message []byte, err error) {
+	rc.connectLazily()
+
+	err = ErrNotConnected
+	if conn := rc.getConn(); conn != nil && rc.isTransportUp() {
+		readTimeout := rc.getReadTimeout()
+		if readTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		rc.readers.Add(1)
+		messageType, message, err = conn.ReadMessage()
+		rc.readers.Add(-1)
+		rc.counters.read.Add(uint64(len(message)))
+		if err == nil {
+			rc.setLastMessageAt()
+		}
+		if err != nil && conn != rc.getConn() {
+			// the connection was replaced while reading
+			return messageType, message, fmt.Errorf("recws: read message: %w: %w", ErrNotConnected, err)
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			rc.closeNormalClosure(err)
+			if rc.getIsClosed() {
+				// the close message answering Close or Shutdown
+				return messageType, message, ErrNotConnected
+			}
+			return messageType, message, fmt.Errorf("recws: read message: %w: %w", ErrClosedByServer, err)
+		}
+		if err != nil && rc.getIsClosed() {
+			// closed by Close or Shutdown while reading
+			return messageType, message, ErrNotConnected
+		}
+		var netErr net.Error
+		if readTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+			rc.verbosef("ReadMessage: no message received within %v, reconnecting", readTimeout)
+			rc.closeAndReconnect(DisconnectReadTimeout, err)
+			return messageType, message, ErrNotConnected
+		}
+		if err != nil {
+			rc.closeAndReconnect(DisconnectReadError, err)
+			err = fmt.Errorf("recws: read message: %w", err)
+		}
+	}
+
+	return
+}
+
+func (rc *RecConn) getReadTimeout() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.ReadTimeout
+}
+
+// ReadMessageRaw is like ReadMessage, but returns the error of the underlying
+// connection as is, without reconnecting or handling the normal closure.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) ReadMessageRaw() (messageType int, message []byte, err error) {
+	rc.connectLazily()
+
+	conn := rc.getConn()
+	if conn == nil || !rc.isTransportUp() {
+		return 0, nil, ErrNotConnected
+	}
+
+	rc.readers.Add(1)
+	messageType, message, err = conn.ReadMessage()
+	rc.readers.Add(-1)
+	rc.counters.read.Add(uint64(len(message)))
+	if err == nil {
+		rc.setLastMessageAt()
+	}
+
+	return messageType, message, err
+}
+
+// WriteMessage is a helper method for getting a writer using NextWriter,
+// writing the message and closing the writer.
+//
+// With a WriteQueueSize, the message is queued while disconnected and
+// written after the next connect, ErrWriteQueueFull is returned when the
+// queue is full.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteMessage(messageType int, data []byte) error {
+	rc.connectLazily()
+
+	if err := rc.awaitWriteHold(context.Background()); err != nil {
+		return err
+	}
+
+	if queued, err := rc.enqueueWrite(messageType, data); queued {
+		return err
+	}
+
+	return rc.writeMessage(messageType, data)
+}
+
+// writeMessage writes the message right away, bypassing the write queue
+func (rc *RecConn) writeMessage(messageType int, data []byte) error {
+	if err := rc.waitWriteLimit(context.Background()); err != nil {
+		return err
+	}
+
+	return rc.writeMessageNow(messageType, data)
+}
+
+// writeMessageNow is like writeMessage, without the WriteRateLimit
+func (rc *RecConn) writeMessageNow(messageType int, data []byte) error {
+	w := rc.lockWriter()
+	if w.conn == nil {
+		return ErrNotConnected
+	}
+	err := w.writeFrames(messageType, data)
+	w.unlock()
+	if err == nil {
+		rc.counters.written.Add(uint64(len(data)))
+		return nil
+	}
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		rc.closeNormalClosure(err)
+		return nil
+	}
+
+	rc.closeAndReconnectGeneration(w.generation, DisconnectWriteError, err)
+	return fmt.Errorf("recws: write message: %w", err)
+}
+
+// connWriter is the current connection locked for a data write by lockWriter
+type connWriter struct {
+	conn         *websocket.Conn
+	generation   uint64
+	maxFrameSize int
+	unlock       func()
+}
+
+// lockWriter locks the data writes and returns the current connection with the
+// write deadline set, or a nil connection if not connected. The write runs
+// without the lock, so a slow write does not block the reader and the
+// keepalive. Call unlock after the write, a failed write only reconnects
+// the connection of the generation.
+func (rc *RecConn) lockWriter() connWriter {
+	rc.writeMu.Lock()
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if !rc.transportUp || rc.Conn == nil {
+		rc.writeMu.Unlock()
+		return connWriter{unlock: func() {}}
+	}
+
+	rc.setWriteDeadline(rc.Conn)
+	return connWriter{
+		conn:         rc.Conn,
+		generation:   rc.generation,
+		maxFrameSize: rc.MaxFrameSize,
+		unlock:       rc.writeMu.Unlock,
+	}
+}
+
+// lockWriterContext is like lockWriter, but returns the connection being
+// prepared if the ctx is the one of the SubscribeContextHandler. A failed
+// write to a connection not installed yet fails its prepare, without
+// reconnecting the current one.
+func (rc *RecConn) lockWriterContext(ctx context.Context) connWriter {
+	conn, _ := ctx.Value(prepareConnKey{}).(*websocket.Conn)
+	if conn == nil {
+		return rc.lockWriter()
+	}
+
+	rc.writeMu.Lock()
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if (conn == rc.Conn && !rc.transportUp) || rc.isClosed {
+		rc.writeMu.Unlock()
+		return connWriter{unlock: func() {}}
+	}
+
+	// the generation the connection is installed with
+	generation := rc.generation
+	if conn != rc.Conn {
+		generation++
+	}
+
+	rc.setWriteDeadline(conn)
+	return connWriter{
+		conn:         conn,
+		generation:   generation,
+		maxFrameSize: rc.MaxFrameSize,
+		unlock:       rc.writeMu.Unlock,
+	}
+}
+
+// writeFrames writes the message, split into continuation frames of MaxFrameSize
+// by the streaming writer if the payload exceeds it.
+// On a failed write the writer is still closed and the error is returned,
+// the caller must reconnect as a partly written message leaves the
+// connection unusable.
+func (w connWriter) writeFrames(messageType int, data []byte) error {
+	if w.maxFrameSize <= 0 || len(data) <= w.maxFrameSize {
+		return w.conn.WriteMessage(messageType, data)
+	}
+
+	fw, err := w.conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		_ = fw.Close()
+		return err
+	}
+
+	return fw.Close()
+}
+
+// WriteText writes data as a text message.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteText(data []byte) error {
+	return rc.WriteMessage(websocket.TextMessage, data)
+}
+
+// WriteString writes s as a text message.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteString(s string) error {
+	return rc.WriteMessage(websocket.TextMessage, []byte(s))
+}
+
+// WriteBinary writes data as a binary message.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteBinary(data []byte) error {
+	return rc.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// WriteJSON writes the JSON encoding of v to the connection.
+//
+// See the documentation for encoding/json Marshal for details about the
+// conversion of Go values to JSON. v is encoded before writing, so an
+// encoding error is returned without writing or closing the connection.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) WriteJSON(v interface{}) error {
+	rc.connectLazily()
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return fmt.Errorf("recws: write json: %w", err)
+	}
+
+	if err := rc.awaitWriteHold(context.Background()); err != nil {
+		return err
+	}
+	if err := rc.waitWriteLimit(context.Background()); err != nil {
+		return err
+	}
+
+	w := rc.lockWriter()
+	if w.conn == nil {
+		return ErrNotConnected
+	}
+	err := w.writeFrames(websocket.TextMessage, buf.Bytes())
+	w.unlock()
+	if err == nil {
+		rc.counters.written.Add(uint64(buf.Len()))
+		return nil
+	}
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		rc.closeNormalClosure(err)
+		return nil
+	}
+
+	rc.closeAndReconnectGeneration(w.generation, DisconnectWriteError, err)
+	return fmt.Errorf("recws: write json: %w", err)
+}
+
+// ReadJSON reads the next JSON-encoded message from the connection and stores
+// it in the value pointed to by v.
+//
+// See the documentation for the encoding/json Unmarshal function for details
+// about the conversion of JSON to a Go value.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadJSON(v interface{}) error {
+	rc.connectLazily()
+
+	err := ErrNotConnected
+	if conn := rc.getConn(); conn != nil && rc.isTransportUp() {
+		rc.readers.Add(1)
+		err = rc.readJSON(conn, v)
+		rc.readers.Add(-1)
+		if err == nil {
+			rc.setLastMessageAt()
+		}
+		if err != nil && conn != rc.getConn() {
+			// the connection was replaced while reading
+			return fmt.Errorf("recws: read json: %w", err)
+		}
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			rc.closeNormalClosure(err)
+			return fmt.Errorf("recws: read json: %w: %w", ErrClosedByServer, err)
+		}
+		if err != nil {
+			rc.closeAndReconnect(DisconnectReadError, err)
+			err = fmt.Errorf("recws: read json: %w", err)
+		}
+	}
+
+	return err
+}
+
+// setDialed marks the connection as dialed, failing if it already was
+func (rc *RecConn) setDialed() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.dialed {
+		return ErrAlreadyDialed
+	}
+	rc.dialed = true
+
+	return nil
+}
+
+// ReadJSONStream reads the next message and calls fn for each JSON value
+// within it, which supports servers batching newline-delimited JSON in one frame.
+// Reading stops at the first error returned by fn.
+//
+// The errors are returned the same way as with ReadMessage.
+func (rc *RecConn) ReadJSONStream(fn func(json.RawMessage) error) error {
+	_, message, err := rc.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(message))
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("recws: read json stream: %w", err)
+		}
+
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+func (rc *RecConn) setURL(u *url.URL) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.parsedURL = u
+	rc.url = u.String()
+}
+
+func (rc *RecConn) setReqHeader(reqHeader http.Header) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.reqHeader = reqHeader
+}
+
+// parseURL parses and validates current url
+func (rc *RecConn) parseURL(urlStr string) (*url.URL, error) {
+	if urlStr == "" {
+		return nil, errors.New("dial: url cannot be empty")
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, errors.New("url: " + err.Error())
+	}
+
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, errors.New("url: websocket uris must start with ws or wss scheme")
+	}
+
+	if u.User != nil {
+		return nil, errors.New("url: user name and password are not allowed in websocket URIs")
+	}
+
+	return u, nil
+}
+
+func (rc *RecConn) setDefaultRecIntvlMin() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.RecIntvlMin == 0 {
+		rc.RecIntvlMin = 2 * time.Second
+	}
+}
+
+func (rc *RecConn) setDefaultRecIntvlMax() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.RecIntvlMax == 0 {
+		rc.RecIntvlMax = 30 * time.Second
+	}
+}
+
+func (rc *RecConn) setDefaultRecIntvlFactor() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.RecIntvlFactor == 0 {
+		rc.RecIntvlFactor = 1.5
+	}
+}
+
+func (rc *RecConn) setDefaultWriteWait() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.WriteWait == 0 {
+		rc.WriteWait = 10 * time.Second
+	}
+}
+
+// setWriteDeadline sets the write deadline for the next write from the WriteWait.
+// Callers must hold the lock.
+func (rc *RecConn) setWriteDeadline(conn *websocket.Conn) {
+	var deadline time.Time
+	if rc.WriteWait > 0 {
+		deadline = time.Now().Add(rc.WriteWait)
+	}
+
+	_ = conn.SetWriteDeadline(deadline)
+}
+
+func (rc *RecConn) setDefaultHandshakeTimeout() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.HandshakeTimeout == 0 {
+		rc.HandshakeTimeout = 2 * time.Second
+	}
+}
+
+func (rc *RecConn) setDefaultProxy() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.Proxy == nil {
+		rc.Proxy = http.ProxyFromEnvironment
+	}
+}
+
+func (rc *RecConn) setDefaultDialer(tlsClientConfig *tls.Config, handshakeTimeout time.Duration, compression bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.dialer = &websocket.Dialer{
+		HandshakeTimeout:  handshakeTimeout,
+		Proxy:             rc.proxy,
+		TLSClientConfig:   tlsClientConfig,
+		EnableCompression: compression,
+	}
+}
+
+// proxy returns the proxy url from the Proxy func,
+// with the ProxyAuth credentials if set
+func (rc *RecConn) proxy(req *http.Request) (*url.URL, error) {
+	rc.mu.RLock()
+	proxy, proxyAuth := rc.Proxy, rc.ProxyAuth
+	rc.mu.RUnlock()
+
+	if proxy == nil {
+		return nil, nil
+	}
+
+	var proxyURL *url.URL
+	err := rc.callHandler("Proxy", func() (err error) {
+		proxyURL, err = proxy(req)
+		return err
+	})
+	if err != nil || proxyURL == nil || proxyAuth == nil || proxyURL.User != nil {
+		return proxyURL, err
+	}
+
+	u := *proxyURL
+	u.User = proxyAuth
+	return &u, nil
+}
+
+func (rc *RecConn) getHandshakeTimeout() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.HandshakeTimeout
+}
+
+func (rc *RecConn) getTLSClientConfig() *tls.Config {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.TLSClientConfig
+}
+
+// withOrigin sets the Origin header, if the Origin is set
+func (rc *RecConn) withOrigin(reqHeader http.Header) http.Header {
+	rc.mu.RLock()
+	origin := rc.Origin
+	rc.mu.RUnlock()
+
+	if origin == "" {
+		return reqHeader
+	}
+
+	reqHeader = reqHeader.Clone()
+	if reqHeader == nil {
+		reqHeader = http.Header{}
+	}
+	reqHeader.Set("Origin", origin)
+
+	return reqHeader
+}
+
+// SetOrigin sets the Origin used by the next dial attempt.
+func (rc *RecConn) SetOrigin(origin string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.Origin = origin
+}
+
+// SetProxy sets the proxy func used by the next dial attempt.
+func (rc *RecConn) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.Proxy = proxy
+}
+
+// warnInsecureSkipVerify logs a warning once if InsecureSkipVerify is set
+func (rc *RecConn) warnInsecureSkipVerify() {
+	rc.mu.RLock()
+	insecure, urlStr := rc.InsecureSkipVerify, rc.url
+	rc.mu.RUnlock()
+
+	if insecure {
+		rc.insecureWarning.Do(func() {
+			rc.logf("WARNING: InsecureSkipVerify is set, the TLS certificate of %s is not verified", urlStr)
+		})
+	}
+}
+
+// insecureTLSClientConfig returns a copy of the TLSClientConfig with InsecureSkipVerify.
+// Callers must hold the lock.
+func (rc *RecConn) insecureTLSClientConfig() *tls.Config {
+	if rc.TLSClientConfig == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+
+	tlsClientConfig := rc.TLSClientConfig.Clone()
+	tlsClientConfig.InsecureSkipVerify = true
+
+	return tlsClientConfig
+}
+
+// SetTLSClientConfig sets the TLS config used by the next dial attempt.
+func (rc *RecConn) SetTLSClientConfig(tlsClientConfig *tls.Config) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.TLSClientConfig = tlsClientConfig
+}
+
+// Dial creates a new client connection.
+// The URL url specifies the host and request URI. Use requestHeader to specify
+// the origin (Origin), subprotocols (Sec-WebSocket-Protocol) and cookies
+// (Cookie). Use GetHTTPResponse() method for the response.Header to get
+// the selected subprotocol (Sec-WebSocket-Protocol) and cookies (Set-Cookie).
+//
+// An invalid url or config (see Validate) is fatal.
+// Calling Dial again on the same connection logs a warning and does nothing.
+func (rc *RecConn) Dial(urlStr string, reqHeader http.Header) {
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		if errors.Is(err, ErrAlreadyDialed) {
+			rc.logf("Dial: %v", err)
+			return
+		}
+		log.Fatalf("Dial: %v", err)
+	}
+
+	if rc.getLazyConnect() {
+		rc.mu.Lock()
+		rc.lazyPending = true
+		rc.mu.Unlock()
+		return
+	}
+
+	// Connect
+	rc.startConnect(0)
+	rc.waitFirstAttempt()
+}
+
+// waitFirstAttempt waits on the first attempt unless SkipDialWait is set
+func (rc *RecConn) waitFirstAttempt() {
+	if rc.getSkipDialWait() {
+		return
+	}
+
+	rc.sleep(rc.getHandshakeTimeout())
+}
+
+func (rc *RecConn) getSkipDialWait() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SkipDialWait
+}
+
+// DialMulti is like Dial, but tries the urls in order on each reconnect attempt,
+// failing over to the next url when one is down. The reconnecting interval
+// applies after a full cycle through the urls. GetURL returns the connected url.
+func (rc *RecConn) DialMulti(urls []string, reqHeader http.Header) {
+	if len(urls) == 0 {
+		log.Fatal("Dial: urls cannot be empty")
+	}
+
+	parsed := make([]*url.URL, len(urls))
+	for i, urlStr := range urls {
+		u, err := rc.parseURL(urlStr)
+		if err != nil {
+			log.Fatalf("Dial: %v", err)
+		}
+		parsed[i] = u
+	}
+
+	rc.mu.Lock()
+	if rc.dialed {
+		rc.mu.Unlock()
+		rc.logf("Dial: %v", ErrAlreadyDialed)
+		return
+	}
+	rc.urls = parsed
+	rc.mu.Unlock()
+
+	rc.Dial(urls[0], reqHeader)
+}
+
+// DialWithTimeout is like Dial, but blocks until the first connection is established
+// or the timeout expires. It returns nil once connected. On timeout it returns
+// the last dial error while the connection keeps being retried in the background.
+// ErrAlreadyDialed is returned if the connection was dialed before.
+func (rc *RecConn) DialWithTimeout(urlStr string, reqHeader http.Header, timeout time.Duration) error {
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		return err
+	}
+
+	var (
+		connected = make(chan struct{})
+		once      sync.Once
+	)
+	remove := rc.AddConnectListener(func() {
+		once.Do(func() { close(connected) })
+	})
+	defer remove()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Connect
+	rc.startConnect(0)
+
+	select {
+	case <-connected:
+		return nil
+	case <-timer.C:
+		if rc.IsConnected() {
+			return nil
+		}
+		if err := rc.GetDialError(); err != nil {
+			return err
+		}
+		return ErrNotConnected
+	}
+}
+
+// configure validates the url and the config, and applies the defaults before connecting.
+func (rc *RecConn) configure(urlStr string, reqHeader http.Header) error {
+	u, err := rc.parseURL(urlStr)
+	if err != nil {
+		return err
+	}
+
+	if err := rc.Validate(); err != nil {
+		return err
+	}
+
+	if err := rc.setDialed(); err != nil {
+		return err
+	}
+
+	// Config
+	rc.setURL(u)
+	rc.setReqHeader(reqHeader)
+	rc.setDefaultRecIntvlMin()
+	rc.setDefaultRecIntvlMax()
+	rc.setDefaultRecIntvlFactor()
+	rc.setDefaultHandshakeTimeout()
+	rc.setDefaultWriteWait()
+	rc.setDefaultPongTimeout()
+	rc.setDefaultProxy()
+	rc.setDefaultDialer(rc.getTLSClientConfig(), rc.getHandshakeTimeout(), rc.Compression)
+
+	return nil
+}
+
+// GetURL returns current connection url
+func (rc *RecConn) GetURL() string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.url
+}
+
+// GetParsedURL returns a copy of the parsed current connection url.
+// nil before Dial.
+func (rc *RecConn) GetParsedURL() *url.URL {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.parsedURL == nil {
+		return nil
+	}
+
+	u := *rc.parsedURL
+	return &u
+}
+
+func (rc *RecConn) getNonVerbose() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.NonVerbose
+}
+
+// newBackoff creates and stores the backoff for a new reconnect session.
+func (rc *RecConn) newBackoff() *backoff.Backoff {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.backoff = &backoff.Backoff{
+		Min:    rc.RecIntvlMin,
+		Max:    rc.RecIntvlMax,
+		Factor: rc.RecIntvlFactor,
+		Jitter: rc.JitterFraction == 0,
+	}
+
+	return rc.backoff
+}
+
+// getBackoffLevel returns the level kept from the previous connect loop
+// with BackoffDecay, 0 otherwise
+func (rc *RecConn) getBackoffLevel() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if !rc.BackoffDecay {
+		return 0
+	}
+
+	return rc.backoffLevel
+}
+
+// setBackoffLevel keeps the level of the backoff after a successful connect,
+// one step below the failed attempts, for BackoffDecay
+func (rc *RecConn) setBackoffLevel(level int, b *backoff.Backoff) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	// the attempts include the successful one
+	rc.backoffLevel = max(level+int(b.Attempt())-2, 0)
+}
+
+func (rc *RecConn) getBackoff() *backoff.Backoff {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.backoff
+}
+
+func (rc *RecConn) getReconnectSchedule() []time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.ReconnectSchedule
+}
+
+// nextInterval advances the backoff and returns the interval for the current attempt,
+// taken from ReconnectSchedule if set. The backoff interval starts at the level
+// kept with BackoffDecay, the attempts and the schedule start from the first
+func (rc *RecConn) nextInterval(b *backoff.Backoff, level int) time.Duration {
+	attempt := int(b.Attempt())
+	nextItvl := b.ForAttempt(float64(level + attempt))
+	b.Duration()
+
+	if schedule := rc.getReconnectSchedule(); len(schedule) > 0 {
+		if attempt >= len(schedule) {
+			attempt = len(schedule) - 1
+		}
+		nextItvl = schedule[attempt]
+	} else if fraction := rc.getJitterFraction(); fraction > 0 {
+		nextItvl += time.Duration(float64(nextItvl) * fraction * (2*rand.Float64() - 1))
+	}
+
+	return nextItvl
+}
+
+// awaitReconnectGap waits until the MinReconnectGap passed since the last
+// dial attempt, and records the start of the next one
+func (rc *RecConn) awaitReconnectGap() {
+	rc.mu.RLock()
+	gap, lastDialAt := rc.MinReconnectGap, rc.lastDialAt
+	rc.mu.RUnlock()
+
+	if gap > 0 && !lastDialAt.IsZero() {
+		if wait := gap - rc.getClock().Now().Sub(lastDialAt); wait > 0 {
+			rc.verbosef("Dial: waiting %v for the MinReconnectGap", wait)
+			rc.sleep(wait)
+		}
+	}
+
+	rc.mu.Lock()
+	rc.lastDialAt = rc.getClock().Now()
+	rc.mu.Unlock()
+}
+
+func (rc *RecConn) getJitterFraction() float64 {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.JitterFraction
+}
+
+func (rc *RecConn) setNextItvl(nextItvl time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.nextItvl = nextItvl
+}
+
+func (rc *RecConn) getMetrics() Metrics {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.Metrics
+}
+
+// HasSubscribeHandler reports whether a SubscribeHandler or
+// a SubscribeContextHandler is set.
+func (rc *RecConn) HasSubscribeHandler() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.SubscribeHandler != nil || rc.SubscribeContextHandler != nil
+}
+
+// HasDisconnectHandler reports whether a DisconnectHandler or
+// a DisconnectContextHandler is set.
+func (rc *RecConn) HasDisconnectHandler() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.DisconnectHandler != nil || rc.DisconnectContextHandler != nil
+}
+
+// HasPongHandler reports whether a PongHandler is set.
+func (rc *RecConn) HasPongHandler() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.PongHandler != nil
+}
+
+func (rc *RecConn) setDefaultPongTimeout() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.PongTimeout == 0 {
+		rc.PongTimeout = 60 * time.Second
+	}
+}
+
+func (rc *RecConn) getKeepAliveTimeout() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.KeepAliveTimeout
+}
+
+// writeControlPingMessage writes the ping without the locks, so it is not
+// delayed by data writes: gorilla writes control frames concurrently,
+// between the frames of a data message
+func (rc *RecConn) writeControlPingMessage(generation uint64) error {
+	live := rc.live.Load()
+	if live == nil || live.generation != generation {
+		return errStaleGeneration
+	}
+
+	return live.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second))
+}
+
+func (rc *RecConn) writeJSONPingMessage(generation uint64, v interface{}) error {
+	w := rc.lockWriter()
+	defer w.unlock()
+
+	if w.conn == nil || w.generation != generation {
+		return errStaleGeneration
+	}
+
+	return w.conn.WriteJSON(v)
+}
+
+func (rc *RecConn) getKeepAlivePingJSON() interface{} {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.KeepAlivePingJSON
+}
+
+// writePingMessage sends the keepalive ping on the connection of the generation,
+// as the JSON data message v if not nil
+func (rc *RecConn) writePingMessage(generation uint64, v interface{}) error {
+	if v != nil {
+		return rc.writeJSONPingMessage(generation, v)
+	}
+
+	return rc.writeControlPingMessage(generation)
+}
+
+// liveConn is the current connection with its generation,
+// read without the lock by the control frame writes
+type liveConn struct {
+	conn       *websocket.Conn
+	generation uint64
+}
+
+// anyGeneration matches the connection of any generation
+const anyGeneration = 0
+
+// errStaleGeneration is returned when the connection of a generation was replaced
+var errStaleGeneration = errors.New("websocket: connection replaced")
+
+func (rc *RecConn) getGeneration() uint64 {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.generation
+}
+
+// isGeneration reports whether the connection of the generation is still up.
+// It does not take the lock, so the keepalive is not delayed by data writes.
+func (rc *RecConn) isGeneration(generation uint64) bool {
+	live := rc.live.Load()
+	return live != nil && live.generation == generation
+}
+
+func (rc *RecConn) getOnPing() func() {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.OnPing
+}
+
+func (rc *RecConn) setLastPongRTT(rtt time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.lastPongRTT = rtt
+}
+
+// LastPongRTT returns the round trip time from the last keepalive ping
+// to its pong. 0 if no pong was received yet.
+func (rc *RecConn) LastPongRTT() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
 
-	return rc.Conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second))
+	return rc.lastPongRTT
 }
 
-func (rc *RecConn) keepAlive() {
+func (rc *RecConn) keepAlive(keepAliveResponse *keepAliveResponse) {
 	var (
-		keepAliveResponse = new(keepAliveResponse)
-		ticker            = time.NewTicker(rc.getKeepAliveTimeout())
+		clock    = rc.getClock()
+		timeout  = rc.getKeepAliveTimeout()
+		ticker   = clock.NewTicker(timeout)
+		pingJSON = rc.getKeepAlivePingJSON()
+		onPing   = rc.getOnPing()
 	)
 
-	rc.mu.Lock()
-	rc.Conn.SetPongHandler(func(msg string) error {
-		keepAliveResponse.setLastResponse()
-		return nil
-	})
-	rc.mu.Unlock()
+	// the keepalive is tied to the current connection and exits once it ends,
+	// a new keepalive is started on the next connect. The loop does not take
+	// the lock, so the pings are not delayed by data writes holding it.
+	generation := rc.getGeneration()
 
 	go func() {
 		defer ticker.Stop()
 
 		for {
-			if !rc.IsConnected() {
-				continue
+			if !rc.isGeneration(generation) {
+				return
 			}
 
-			if err := rc.writeControlPingMessage(); err != nil {
-				log.Println(err)
+			pingAt := clock.Now()
+			keepAliveResponse.setLastPing(pingAt)
+			if err := rc.writePingMessage(generation, pingJSON); err != nil {
+				if errors.Is(err, errStaleGeneration) {
+					return
+				}
+				rc.keepAlivef("KeepAlive: ping failed, reconnecting: %v", err)
+				rc.closeAndReconnectGeneration(generation, DisconnectWriteError, err)
+				return
+			}
+			if onPing != nil {
+				_ = rc.callHandler("OnPing", func() error {
+					onPing()
+					return nil
+				})
 			}
 
-			<-ticker.C
-			if time.Since(keepAliveResponse.getLastResponse()) > rc.getKeepAliveTimeout() {
-				rc.CloseAndReconnect()
+			<-ticker.C()
+			// measured from the ping, as the tick may lag behind a prompt pong
+			if keepAliveResponse.getLastResponse().Before(pingAt) {
+				if !rc.isGeneration(generation) {
+					return
+				}
+				rc.keepAlivef("KeepAlive: no pong received within %v, reconnecting", timeout)
+				rc.closeAndReconnectGeneration(generation, DisconnectKeepAliveTimeout, nil)
 				return
 			}
 		}
 	}()
 }
 
+func (rc *RecConn) getDialDeadline() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.DialDeadline
+}
+
+// getDialURLs returns the urls to try on each dial attempt, in order
+func (rc *RecConn) getDialURLs() []*url.URL {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if len(rc.urls) > 0 {
+		return rc.urls
+	}
+
+	return []*url.URL{rc.parsedURL}
+}
+
+// dial performs a single dial attempt, trying each url in order until one succeeds.
+// The connected url becomes the current url.
+// prevResp is the response of the previous attempt, nil on the first one.
+func (rc *RecConn) dial(prevResp *http.Response, attempt int) (wsConn *websocket.Conn, httpResp *http.Response, handshakeDuration time.Duration, err error) {
+	urls := rc.getDialURLs()
+	for _, u := range urls {
+		wsConn, httpResp, handshakeDuration, err = rc.dialURL(u.String(), prevResp, attempt)
+		if err == nil {
+			rc.setURL(u)
+			return wsConn, httpResp, handshakeDuration, nil
+		}
+
+		if len(urls) > 1 {
+			rc.verbosef("Dial: %s failed: %v", u, err)
+		}
+	}
+
+	return wsConn, httpResp, 0, err
+}
+
+// dialURL dials the url bounded by DialDeadline, and aborts the dial once the
+// connection is closed. The handshake duration only covers the dial, not the
+// NextHeaders and ConfigureDialer callbacks.
+func (rc *RecConn) dialURL(urlStr string, prevResp *http.Response, attempt int) (*websocket.Conn, *http.Response, time.Duration, error) {
+	reqHeader, err := rc.getReqHeader(prevResp, attempt)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	ctx, cancel := rc.closedContext()
+	defer cancel()
+	if deadline := rc.getDialDeadline(); deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	dialer := rc.getDialer()
+	if configureDialer := rc.getConfigureDialer(); configureDialer != nil {
+		_ = rc.callHandler("ConfigureDialer", func() error {
+			configureDialer(dialer, attempt)
+			return nil
+		})
+	}
+
+	dialStart := rc.getClock().Now()
+	wsConn, httpResp, err := dialer.DialContext(ctx, urlStr, reqHeader)
+
+	return wsConn, httpResp, rc.getClock().Now().Sub(dialStart), err
+}
+
+func (rc *RecConn) getConfigureDialer() func(d *websocket.Dialer, attempt int) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.ConfigureDialer
+}
+
+// getReqHeader returns the request header for a dial attempt,
+// provided by NextHeaders if set, with the resume token on reconnects
+// and the Origin
+func (rc *RecConn) getReqHeader(prevResp *http.Response, attempt int) (http.Header, error) {
+	rc.mu.RLock()
+	reqHeader, nextHeaders := rc.reqHeader, rc.NextHeaders
+	rc.mu.RUnlock()
+
+	if nextHeaders != nil {
+		err := rc.callHandler("NextHeaders", func() (err error) {
+			reqHeader, err = nextHeaders(prevResp, attempt)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("recws: next headers: %w", err)
+		}
+	}
+
+	return rc.withOrigin(rc.withResumeToken(reqHeader)), nil
+}
+
+// getDialer returns a copy of the dialer updated from the current config,
+// so changes made after Dial apply on the next dial attempt
+func (rc *RecConn) getDialer() *websocket.Dialer {
+	netDialContext := rc.netDialContext()
+	rc.warnInsecureSkipVerify()
+
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	dialer := *rc.dialer
+	if netDialContext != nil {
+		dialer.NetDialContext = netDialContext
+	}
+	dialer.TLSClientConfig = rc.TLSClientConfig
+	if rc.InsecureSkipVerify {
+		dialer.TLSClientConfig = rc.insecureTLSClientConfig()
+	}
+	dialer.EnableCompression = rc.Compression
+	if rc.HandshakeTimeout != 0 {
+		dialer.HandshakeTimeout = rc.HandshakeTimeout
+	}
+	if rc.MaxFrameSize > 0 {
+		// the streaming writer flushes a frame whenever the write buffer is full
+		dialer.WriteBufferSize = rc.MaxFrameSize
+	}
+
+	return &dialer
+}
+
+// setConn installs a newly established connection.
+// Callers must hold the write lock.
+func (rc *RecConn) setConn(wsConn *websocket.Conn, httpResp *http.Response, handshakeDuration time.Duration) {
+	// the handlers are installed before the connection is published to the reader
+	keepAliveResponse := new(keepAliveResponse)
+	var pongTimeout time.Duration
+	if rc.ReadDeadlineOnPong {
+		pongTimeout = rc.PongTimeout
+	}
+	if pongTimeout > 0 {
+		_ = wsConn.SetReadDeadline(time.Now().Add(pongTimeout))
+	}
+	rc.installPongHandler(wsConn, func() {
+		now := rc.getClock().Now()
+		keepAliveResponse.setLastResponse(now)
+		if lastPing := keepAliveResponse.takeLastPing(); !lastPing.IsZero() {
+			rc.setLastPongRTT(now.Sub(lastPing))
+		}
+		if pongTimeout > 0 {
+			_ = wsConn.SetReadDeadline(time.Now().Add(pongTimeout))
+		}
+	})
+	rc.installCloseHandler(wsConn)
+	rc.keepAliveResponse = keepAliveResponse
+
+	rc.Conn = wsConn
+	rc.generation++
+	rc.live.Store(&liveConn{conn: wsConn, generation: rc.generation})
+	rc.httpResp = httpResp
+	// IsConnected reports true once the connection is prepared, see connected
+	rc.transportUp = true
+	rc.isConnected = false
+	rc.updateConnectedCh()
+	rc.connClosedCh = make(chan struct{})
+	rc.extensions = negotiatedExtensions(httpResp)
+	rc.compressionEnabled = isCompressionNegotiated(rc.extensions)
+	if rc.writeCompression != nil {
+		wsConn.EnableWriteCompression(*rc.writeCompression)
+	}
+	rc.handshakeDuration = handshakeDuration
+	rc.lastConnectedAt = rc.getClock().Now()
+	if rc.connectCount > 0 {
+		rc.reconnectCount++
+	}
+	rc.connectCount++
+}
+
+// connected marks the connection as connected once it is subscribed and ready,
+// and runs the post connect hooks
+func (rc *RecConn) connected(httpResp *http.Response, handshakeDuration time.Duration) {
+	rc.setIsConnected(true)
+	rc.captureResumeToken(httpResp)
+
+	if onConnect := rc.getMetrics().OnConnect; onConnect != nil {
+		_ = rc.callHandler("OnConnect", func() error {
+			onConnect(handshakeDuration, rc.getTags())
+			return nil
+		})
+	}
+
+	if rc.getKeepAliveTimeout() != 0 {
+		rc.mu.RLock()
+		keepAliveResponse := rc.keepAliveResponse
+		rc.mu.RUnlock()
+
+		rc.keepAlive(keepAliveResponse)
+	}
+
+	go rc.flushWriteQueue()
+	rc.notifyConnect()
+}
+
+// recovered fires the OnRecover handler.
+// The downtime is measured from LastDisconnectedAt, or from the start
+// of the connect loop if the connection was never established.
+func (rc *RecConn) recovered(sessionStart time.Time, attempts int) {
+	rc.mu.RLock()
+	onRecover, disconnectedAt := rc.OnRecover, rc.lastDisconnectedAt
+	rc.mu.RUnlock()
+
+	if onRecover == nil {
+		return
+	}
+
+	if disconnectedAt.IsZero() {
+		disconnectedAt = sessionStart
+	}
+
+	_ = rc.callHandler("OnRecover", func() error {
+		onRecover(rc.getClock().Now().Sub(disconnectedAt), attempts)
+		return nil
+	})
+}
+
+// getFirstReconnectDelay returns the FirstReconnectDelay on reconnects, 0 on the initial dial
+func (rc *RecConn) getFirstReconnectDelay() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if rc.connectCount == 0 {
+		return 0
+	}
+
+	return rc.FirstReconnectDelay
+}
+
+// startConnect launches the connect loop after the delay,
+// unless a connect loop is already running
+func (rc *RecConn) startConnect(delay time.Duration) {
+	if rc.getIsShutdown() || !rc.beginReconnecting() {
+		return
+	}
+
+	done := make(chan struct{})
+	rc.mu.Lock()
+	rc.connectDone = done
+	rc.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		defer rc.endReconnecting()
+
+		if delay > 0 {
+			rc.sleep(delay)
+		}
+		rc.connect()
+	}()
+}
+
+// beginReconnecting reports whether the caller may launch the connect loop.
+// While a connect loop is running, a reconnect is requested from it instead.
+func (rc *RecConn) beginReconnecting() bool {
+	for {
+		if rc.isReconnecting.CompareAndSwap(false, true) {
+			return true
+		}
+
+		rc.reconnectRequested.Store(true)
+		if rc.isReconnecting.Load() {
+			// the running loop sees the request when it exits
+			return false
+		}
+	}
+}
+
+// endReconnecting marks the connect loop as stopped. A reconnect requested
+// while the loop completed its connect (e.g. from a connect listener) starts
+// a new loop if the connection was lost meanwhile.
+func (rc *RecConn) endReconnecting() {
+	rc.isReconnecting.Store(false)
+
+	if rc.reconnectRequested.Swap(false) && !rc.IsConnected() && !rc.getIsClosed() {
+		rc.startConnect(0)
+	}
+}
+
+// IsReconnecting reports whether the connect loop is running
+func (rc *RecConn) IsReconnecting() bool {
+	return rc.isReconnecting.Load()
+}
+
 func (rc *RecConn) connect() {
-	b := rc.getBackoff()
+	b := rc.newBackoff()
+	level := rc.getBackoffLevel()
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	var (
+		prevResp     *http.Response
+		failures     int
+		redirects    int
+		sessionStart = rc.getClock().Now()
+	)
+
+	if delay := rc.getFirstReconnectDelay(); delay > 0 {
+		rc.verbosef("Dial: will reconnect in %v", delay)
+		rc.sleep(delay)
+	}
+
 	for {
-		nextItvl := b.Duration()
-		wsConn, httpResp, err := rc.dialer.Dial(rc.url, rc.reqHeader)
+		if rc.getIsClosed() {
+			return
+		}
+
+		rc.awaitDisconnect()
+
+		nextItvl := rc.nextInterval(b, level)
+		rc.setNextItvl(nextItvl)
+		rc.awaitReconnectGap()
+		rc.waitReconnectLimit()
+		if rc.getIsClosed() {
+			return
+		}
+		rc.emit(EventReconnecting, int(b.Attempt()), nil)
+		wsConn, httpResp, handshakeDuration, err := rc.dial(prevResp, int(b.Attempt()))
+		prevResp = httpResp
 
 		rc.mu.Lock()
-		rc.Conn = wsConn
+		if rc.isClosed {
+			// closed while dialing
+			rc.mu.Unlock()
+			if wsConn != nil {
+				wsConn.Close()
+			}
+			return
+		}
 		rc.dialErr = err
-		rc.isConnected = err == nil
-		rc.httpResp = httpResp
+		if err == nil {
+			rc.setConn(wsConn, httpResp, handshakeDuration)
+		} else {
+			rc.recordDialError(err)
+			rc.Conn = nil
+			rc.httpResp = httpResp
+			rc.transportUp = false
+			rc.live.Store(nil)
+			rc.isConnected = false
+			rc.updateConnectedCh()
+			rc.compressionEnabled = false
+			rc.extensions = nil
+		}
 		rc.mu.Unlock()
 
 		if err == nil {
-			if !rc.getNonVerbose() {
-				log.Printf("Dial: connection was successfully established with %s\n", rc.url)
-			}
+			rc.verbosef("Dial: connection was successfully established with %s", rc.GetURL())
 
-			if rc.hasSubscribeHandler() {
-				if err := rc.SubscribeHandler(); err != nil {
+			rc.beginConnecting()
+			err := rc.prepare()
+			if err != nil {
+				if !errors.Is(err, ErrSubscribeTimeout) && !errors.Is(err, ErrNotConnected) && !errors.Is(err, ErrNotReady) && !isHandlerPanic(err) {
 					log.Fatalf("Dial: connect handler failed with %s", err.Error())
 				}
-				if !rc.getNonVerbose() {
-					log.Printf("Dial: connect handler was successfully established with %s\n", rc.url)
+
+				rc.verbosef("Dial: %v, will try again in %v seconds.", err, nextItvl)
+				rc.emit(EventFailed, int(b.Attempt()), err)
+				rc.close(true, prepareFailedReason(err), err)
+				rc.endConnecting()
+				failures++
+				if rc.shouldGiveUp(failures) {
+					rc.giveUp(failures, err)
+					return
 				}
+				rc.sleep(nextItvl)
+				continue
 			}
 
-			if rc.getKeepAliveTimeout() != 0 {
-				rc.keepAlive()
+			rc.connected(httpResp, handshakeDuration)
+			rc.emit(EventConnected, int(b.Attempt()), nil)
+			rc.endConnecting()
+			rc.setBackoffLevel(level, b)
+
+			if failures > 0 {
+				rc.recovered(sessionStart, failures)
 			}
 
 			return
 		}
 
-		if !rc.getNonVerbose() {
-			log.Println(err)
-			log.Println("Dial: will try again in", nextItvl, "seconds.")
+		if follow, maxRedirects := rc.getFollowRedirects(); follow && redirects < maxRedirects && rc.redirect(httpResp) {
+			redirects++
+			continue
+		}
+		// only redirects in a row count towards the MaxRedirects
+		redirects = 0
+
+		rc.emit(EventFailed, int(b.Attempt()), err)
+		failures++
+		if rc.isFailFastStatus(httpResp) {
+			rc.giveUp(failures, fmt.Errorf("recws: handshake failed with status %d: %w", httpResp.StatusCode, err))
+			return
+		}
+		if rc.shouldGiveUp(failures) {
+			rc.giveUp(failures, err)
+			return
 		}
 
-		time.Sleep(nextItvl)
+		rc.verbosef("Dial: attempt %d to %s failed (%s): %v, will try again in %v seconds.",
+			int(b.Attempt()), rc.logName(), dialErrorClass(err), err, nextItvl)
+
+		rc.sleep(nextItvl)
 	}
 }
 
@@ -482,10 +2245,231 @@ func (rc *RecConn) GetDialError() error {
 	return rc.dialErr
 }
 
-// IsConnected returns the WebSocket connection state
+// LastHandshakeDuration returns how long the last successful handshake took.
+// 0 if no connection was established yet.
+func (rc *RecConn) LastHandshakeDuration() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.handshakeDuration
+}
+
+// GetReconnectAttempt returns the number of dial attempts made
+// in the current reconnect session.
+func (rc *RecConn) GetReconnectAttempt() int {
+	b := rc.getBackoff()
+	if b == nil {
+		return 0
+	}
+
+	return int(b.Attempt())
+}
+
+// GetReconnectInterval returns the interval computed by the backoff
+// for the latest dial attempt.
+func (rc *RecConn) GetReconnectInterval() time.Duration {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.nextItvl
+}
+
+// IsTLS reports whether the current url uses the wss scheme.
+func (rc *RecConn) IsTLS() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.parsedURL != nil && rc.parsedURL.Scheme == "wss"
+}
+
+// UnderlyingConn returns the current connection for features that
+// RecConn does not wrap, nil if not connected. The connection is locked
+// until release is called: writes of RecConn and reconnects
+// wait meanwhile, so release it right after use and do not call other
+// methods of RecConn before. Do not keep the connection after release,
+// as it may be replaced by a reconnect.
+func (rc *RecConn) UnderlyingConn() (conn *websocket.Conn, release func()) {
+	rc.writeMu.Lock()
+	rc.mu.RLock()
+	if !rc.transportUp || rc.Conn == nil {
+		rc.mu.RUnlock()
+		rc.writeMu.Unlock()
+		return nil, func() {}
+	}
+
+	var once sync.Once
+	return rc.Conn, func() {
+		once.Do(func() {
+			rc.mu.RUnlock()
+			rc.writeMu.Unlock()
+		})
+	}
+}
+
+// ConnectionState returns the TLS details of the current connection.
+// nil if not connected or the connection is not using TLS.
+func (rc *RecConn) ConnectionState() *tls.ConnectionState {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	if !rc.transportUp || rc.Conn == nil {
+		return nil
+	}
+
+	tlsConn, ok := rc.Conn.NetConn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := tlsConn.ConnectionState()
+	return &state
+}
+
+// IsCompressionEnabled reports whether permessage-deflate was negotiated
+// with the server on the current connection.
+func (rc *RecConn) IsCompressionEnabled() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.compressionEnabled
+}
+
+// NegotiatedExtensions returns the extensions accepted by the server
+// (Sec-WebSocket-Extensions) on the current connection, including their parameters.
+func (rc *RecConn) NegotiatedExtensions() []string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return append([]string(nil), rc.extensions...)
+}
+
+// negotiatedExtensions parses the extensions from the handshake response
+func negotiatedExtensions(httpResp *http.Response) []string {
+	if httpResp == nil {
+		return nil
+	}
+
+	var extensions []string
+	for _, header := range httpResp.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				extensions = append(extensions, ext)
+			}
+		}
+	}
+
+	return extensions
+}
+
+// EnableWriteCompression enables and disables write compression of subsequent
+// messages, like websocket.Conn.EnableWriteCompression, if compression was
+// negotiated. The setting is applied to the current connection and kept for
+// the reconnects.
+func (rc *RecConn) EnableWriteCompression(enable bool) {
+	// the data writes hold the writeMu, not the lock
+	rc.writeMu.Lock()
+	defer rc.writeMu.Unlock()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.writeCompression = &enable
+	if rc.Conn != nil {
+		rc.Conn.EnableWriteCompression(enable)
+	}
+}
+
+// isCompressionNegotiated checks the extensions for permessage-deflate
+func isCompressionNegotiated(extensions []string) bool {
+	for _, ext := range extensions {
+		name, _, _ := strings.Cut(ext, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Connected returns a channel that is closed once the connection is established
+// and subscribed, like IsConnected.
+// A new channel is created on disconnect, so the channel must be fetched again
+// after a disconnect to wait for the next connection.
+func (rc *RecConn) Connected() <-chan struct{} {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.connectedCh == nil {
+		rc.connectedCh = make(chan struct{})
+		rc.updateConnectedCh()
+	}
+
+	return rc.connectedCh
+}
+
+// updateConnectedCh syncs the Connected channel with isConnected.
+// Callers must hold the write lock.
+func (rc *RecConn) updateConnectedCh() {
+	if rc.connectedCh == nil {
+		return
+	}
+
+	select {
+	case <-rc.connectedCh:
+		if !rc.isConnected {
+			rc.connectedCh = make(chan struct{})
+		}
+	default:
+		if rc.isConnected {
+			close(rc.connectedCh)
+		}
+	}
+}
+
+// LastConnectedAt returns when the connection was last established.
+func (rc *RecConn) LastConnectedAt() time.Time {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.lastConnectedAt
+}
+
+// LastMessageAt returns the time the last message was read, also by the
+// background reader. Zero if no message was read yet.
+func (rc *RecConn) LastMessageAt() time.Time {
+	if nanos := rc.lastMessageAt.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+
+	return time.Time{}
+}
+
+func (rc *RecConn) setLastMessageAt() {
+	rc.lastMessageAt.Store(rc.getClock().Now().UnixNano())
+}
+
+// LastDisconnectedAt returns when the connection was last lost or closed.
+func (rc *RecConn) LastDisconnectedAt() time.Time {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.lastDisconnectedAt
+}
+
+// IsConnected returns the WebSocket connection state. A new connection
+// is connected once the SubscribeHandler and the ReadyCheck succeeded.
 func (rc *RecConn) IsConnected() bool {
 	rc.mu.RLock()
 	defer rc.mu.RUnlock()
 
 	return rc.isConnected
 }
+
+// isTransportUp reports whether a connection is installed, also while the
+// SubscribeHandler and the ReadyCheck run before it is connected, so the
+// read and write helpers work for them
+func (rc *RecConn) isTransportUp() bool {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.transportUp
+}
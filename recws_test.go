@@ -0,0 +1,914 @@
+package recws
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/recws-org/recws/recwstest"
+)
+
+// newTestConn returns a RecConn reconnecting fast, for the tests
+func newTestConn() *RecConn {
+	return &RecConn{
+		RecIntvlMin:      10 * time.Millisecond,
+		RecIntvlMax:      50 * time.Millisecond,
+		HandshakeTimeout: time.Second,
+		SkipDialWait:     true,
+		NonVerbose:       true,
+	}
+}
+
+// waitFor fails the test if cond does not become true within 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSingleConnectLoop(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var inFlight, maxInFlight atomic.Int32
+	rc := newTestConn()
+	rc.PreDial = func(ctx context.Context) (net.Conn, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			if m := maxInFlight.Load(); n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		// widen the window for a concurrent connect loop
+		time.Sleep(5 * time.Millisecond)
+
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+	rc.Dial(srv.WSURL(), nil)
+	waitFor(t, "connect", rc.IsConnected)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				rc.CloseAndReconnect()
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, "reconnect", func() bool { return rc.IsConnected() && !rc.IsReconnecting() })
+	if got := maxInFlight.Load(); got != 1 {
+		t.Fatalf("got %d concurrent dial attempts, want a single connect loop", got)
+	}
+}
+
+func TestReconnectAfterDropInConnectListener(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	rc := newTestConn()
+	rc.Messages()
+
+	var first atomic.Bool
+	rc.AddConnectListener(func() {
+		if !first.CompareAndSwap(false, true) {
+			return
+		}
+
+		// drop the connection while the connect loop is still completing,
+		// on the client as the server may not track it yet
+		conn, release := rc.UnderlyingConn()
+		_ = conn.NetConn().Close()
+		release()
+		for deadline := time.Now().Add(5 * time.Second); rc.IsConnected() && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+	rc.Dial(srv.WSURL(), nil)
+
+	waitFor(t, "reconnect", func() bool { return srv.Connections() == 2 && rc.IsConnected() })
+}
+
+func TestShutdownWithReaderDoesNotFireDisconnectHandler(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var disconnects atomic.Int32
+	rc := newTestConn()
+	rc.DisconnectHandler = func() { disconnects.Add(1) }
+	rc.AddDisconnectListener(func(DisconnectReason, error) { disconnects.Add(1) })
+	rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+	waitFor(t, "connect", rc.IsConnected)
+
+	start := time.Now()
+	if err := rc.Shutdown(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Shutdown took %v, want it to return on the close message of the server", elapsed)
+	}
+	if got := disconnects.Load(); got != 0 {
+		t.Fatalf("got %d disconnect callbacks on Shutdown, want 0", got)
+	}
+}
+
+func TestReconnectAfterAbnormalClosure(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true, DropAfter: 2})
+	defer srv.Close()
+
+	var reasons []DisconnectReason
+	var mu sync.Mutex
+	rc := newTestConn()
+	rc.AddDisconnectListener(func(reason DisconnectReason, _ error) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons = append(reasons, reason)
+	})
+	rc.Dial(srv.WSURL(), nil)
+	waitFor(t, "connect", rc.IsConnected)
+
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := rc.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("got %q, %v, want the echo", msg, err)
+	}
+
+	// the second message makes the server drop the connection
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("drop")); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := rc.ReadMessage()
+	for err == nil {
+		_, _, err = rc.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseAbnormalClosure {
+		t.Fatalf("got %v, want an abnormal closure (1006)", err)
+	}
+
+	waitFor(t, "reconnect", func() bool { return srv.Connections() == 2 && rc.IsConnected() })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) != 1 || reasons[0] != DisconnectReadError {
+		t.Fatalf("got disconnect reasons %v, want a single DisconnectReadError", reasons)
+	}
+
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := rc.ReadMessage(); err != nil || string(msg) != "again" {
+		t.Fatalf("got %q, %v, want the echo on the new connection", msg, err)
+	}
+}
+
+// newBlackHole returns the address of a listener that accepts connections, but
+// never answers, like an unroutable address
+func newBlackHole(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestDialDeadline(t *testing.T) {
+	rc := newTestConn()
+	rc.HandshakeTimeout = 10 * time.Second
+	rc.DialDeadline = 100 * time.Millisecond
+	events := rc.Events()
+
+	start := time.Now()
+	rc.Dial("ws://"+newBlackHole(t), nil)
+	defer rc.Close()
+
+	for ev := range events {
+		if ev.Kind != EventFailed {
+			continue
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("first dial failed after %v, want it bounded by the DialDeadline", elapsed)
+		}
+		return
+	}
+}
+
+// newTLSEchoServer starts a wss echo server, returning its url and
+// a client TLS config trusting its certificate
+func newTLSEchoServer(t *testing.T) (wsURL string, tlsConfig *tls.Config) {
+	t.Helper()
+
+	var upgrader websocket.Upgrader
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tlsConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return "wss" + strings.TrimPrefix(srv.URL, "https"), tlsConfig
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// echo writes the message and fails the test unless it is echoed back
+func echo(t *testing.T, rc *RecConn, msg string) {
+	t.Helper()
+
+	if err := rc.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, err := rc.ReadMessage(); err != nil || string(got) != msg {
+		t.Fatalf("got %q, %v, want the echo of %q", got, err, msg)
+	}
+}
+
+func TestKeyLogWriterOnReconnect(t *testing.T) {
+	wsURL, tlsConfig := newTLSEchoServer(t)
+
+	var keyLog syncBuffer
+	tlsConfig.KeyLogWriter = &keyLog
+	rc := newTestConn()
+	rc.TLSClientConfig = tlsConfig
+	rc.Dial(wsURL, nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+	echo(t, rc, "first")
+
+	handshakes := func() int { return strings.Count(keyLog.String(), "CLIENT_HANDSHAKE_TRAFFIC_SECRET") }
+	if got := handshakes(); got != 1 {
+		t.Fatalf("got %d logged handshakes, want 1", got)
+	}
+
+	rc.CloseAndReconnect()
+	waitFor(t, "reconnect", func() bool { return rc.IsConnected() && handshakes() == 2 })
+	echo(t, rc, "second")
+}
+
+func TestSetTLSClientConfigOnReconnect(t *testing.T) {
+	wsURL, tlsConfig := newTLSEchoServer(t)
+
+	var oldVerified, newVerified atomic.Int32
+	oldConfig, newConfig := tlsConfig.Clone(), tlsConfig.Clone()
+	oldConfig.VerifyConnection = func(tls.ConnectionState) error {
+		oldVerified.Add(1)
+		return nil
+	}
+	newConfig.VerifyConnection = func(tls.ConnectionState) error {
+		newVerified.Add(1)
+		return nil
+	}
+
+	rc := newTestConn()
+	rc.TLSClientConfig = oldConfig
+	rc.Dial(wsURL, nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	rc.SetTLSClientConfig(newConfig)
+	rc.CloseAndReconnect()
+	waitFor(t, "reconnect", func() bool { return rc.IsConnected() && newVerified.Load() == 1 })
+	echo(t, rc, "hello")
+
+	if got := oldVerified.Load(); got != 1 {
+		t.Fatalf("got %d handshakes with the old config, want 1", got)
+	}
+}
+
+func TestReadJSONDuringReconnects(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	rc := newTestConn()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var decoded atomic.Int32
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			srv.Broadcast(websocket.TextMessage, []byte(`{"n":1}`))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			var v struct{ N int }
+			if err := rc.ReadJSON(&v); err != nil {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if v.N != 1 {
+				t.Errorf("got %+v, want the broadcast message", v)
+			}
+			decoded.Add(1)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		if i%2 == 0 {
+			rc.ForceReconnect()
+		} else {
+			rc.CloseAndReconnect()
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	waitFor(t, "reconnect", func() bool { return rc.IsConnected() && !rc.IsReconnecting() })
+	n := decoded.Load()
+	waitFor(t, "a message after the reconnects", func() bool { return decoded.Load() > n })
+
+	close(stop)
+	rc.Close()
+	wg.Wait()
+	if got := srv.Connections(); got < 2 {
+		t.Fatalf("got %d connections, want reconnects", got)
+	}
+}
+
+// newConnectProxy starts an HTTP CONNECT proxy requiring the basic auth
+// of user and password, returning its url and the number of tunnels
+func newConnectProxy(t *testing.T, user, password string) (proxyURL *url.URL, tunnels *atomic.Int32) {
+	t.Helper()
+
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	tunnels = new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			http.Error(w, "proxy auth required", http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		client, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+
+		tunnels.Add(1)
+		if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+		go func() { _, _ = io.Copy(target, client) }()
+		_, _ = io.Copy(client, target)
+	}))
+	t.Cleanup(srv.Close)
+
+	proxyURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return proxyURL, tunnels
+}
+
+func TestProxyAuth(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+	proxyURL, tunnels := newConnectProxy(t, "user", "secret")
+
+	rc := newTestConn()
+	rc.Proxy = http.ProxyURL(proxyURL)
+	rc.ProxyAuth = url.UserPassword("user", "secret")
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+	echo(t, rc, "hello")
+
+	if got := tunnels.Load(); got != 1 {
+		t.Fatalf("got %d proxy tunnels, want 1", got)
+	}
+}
+
+func TestProxyAuthRequired(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+	proxyURL, tunnels := newConnectProxy(t, "user", "secret")
+
+	rc := newTestConn()
+	rc.Proxy = http.ProxyURL(proxyURL)
+	events := rc.Events()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	for ev := range events {
+		if ev.Kind == EventFailed {
+			break
+		}
+	}
+	if rc.IsConnected() || tunnels.Load() != 0 || srv.Connections() != 0 {
+		t.Fatal("connected through the proxy without ProxyAuth")
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// faultyConn wraps a net.Conn, recording the written bytes and failing
+// the writes once fail is set or failAfter bytes were written.
+// Each write is delayed by writeDelay, to simulate a slow network.
+type faultyConn struct {
+	net.Conn
+	written    syncBuffer
+	fail       atomic.Bool
+	failAfter  atomic.Int64
+	writeDelay atomic.Int64
+}
+
+func (c *faultyConn) Write(p []byte) (int, error) {
+	time.Sleep(time.Duration(c.writeDelay.Load()))
+	if limit := c.failAfter.Load(); limit > 0 && int64(len(c.written.String())+len(p)) > limit {
+		c.fail.Store(true)
+	}
+	if c.fail.Load() {
+		return 0, errWriteFailed
+	}
+	_, _ = c.written.Write(p)
+
+	return c.Conn.Write(p)
+}
+
+// faultyDialer returns a NetDialContext wrapping each connection
+// in a faultyConn, which is sent on conns
+func faultyDialer(conns chan<- *faultyConn) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		fc := &faultyConn{Conn: conn}
+		conns <- fc
+		return fc, nil
+	}
+}
+
+// frame is the header of a frame written by the client
+type frame struct {
+	fin    bool
+	opcode int
+	size   int
+}
+
+// clientFrames parses the frames written by the client after the handshake request
+func clientFrames(t *testing.T, written string) []frame {
+	t.Helper()
+
+	_, data, ok := strings.Cut(written, "\r\n\r\n")
+	if !ok {
+		t.Fatal("no handshake request written")
+	}
+
+	var frames []frame
+	for len(data) > 0 {
+		if len(data) < 2 {
+			t.Fatalf("truncated frame header %q", data)
+		}
+		f := frame{fin: data[0]&0x80 != 0, opcode: int(data[0] & 0x0f), size: int(data[1] & 0x7f)}
+		header := 2
+		switch f.size {
+		case 126:
+			f.size = int(binary.BigEndian.Uint16([]byte(data[2:4])))
+			header += 2
+		case 127:
+			f.size = int(binary.BigEndian.Uint64([]byte(data[2:10])))
+			header += 8
+		}
+		// the mask key of client frames
+		header += 4
+		if len(data) < header+f.size {
+			t.Fatalf("truncated frame %+v", f)
+		}
+		frames = append(frames, f)
+		data = data[header+f.size:]
+	}
+
+	return frames
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	conns := make(chan *faultyConn, 1)
+	rc := newTestConn()
+	rc.MaxFrameSize = 1024
+	rc.NetDialContext = faultyDialer(conns)
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	msg := strings.Repeat("0123456789", 1024)
+	echo(t, rc, msg)
+
+	frames := clientFrames(t, (<-conns).written.String())
+	if len(frames) != 10 {
+		t.Fatalf("got %d frames, want the message split into 10", len(frames))
+	}
+	for i, f := range frames {
+		if f.size > rc.MaxFrameSize {
+			t.Fatalf("frame %d has %d bytes, want at most %d", i, f.size, rc.MaxFrameSize)
+		}
+		if last := i == len(frames)-1; f.fin != last {
+			t.Fatalf("frame %d has fin %v, want %v", i, f.fin, last)
+		}
+	}
+	if frames[0].opcode != websocket.TextMessage || frames[1].opcode != 0 {
+		t.Fatalf("got opcodes %d and %d, want a text frame and continuation frames", frames[0].opcode, frames[1].opcode)
+	}
+}
+
+func TestFailedStreamingWriteReconnects(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	conns := make(chan *faultyConn, 2)
+	rc := newTestConn()
+	rc.MaxFrameSize = 256
+	rc.NetDialContext = faultyDialer(conns)
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	// fail the write after the first frames of the message
+	first := <-conns
+	first.failAfter.Store(int64(len(first.written.String()) + 600))
+	msg := strings.Repeat("0123456789", 200)
+	if err := rc.WriteJSON(msg); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("got %v, want the failed write", err)
+	}
+	if frames := clientFrames(t, first.written.String()); len(frames) != 2 || frames[1].fin {
+		t.Fatalf("got frames %+v, want a partly written message", frames)
+	}
+
+	waitFor(t, "reconnect", func() bool { return srv.Connections() == 2 && rc.IsConnected() })
+	if err := rc.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := rc.ReadJSON(&got); err != nil || got != msg {
+		t.Fatalf("got %d bytes, %v, want the echo of the message", len(got), err)
+	}
+	if frames := clientFrames(t, (<-conns).written.String()); !frames[len(frames)-1].fin {
+		t.Fatalf("got frames %+v, want a complete message", frames)
+	}
+}
+
+func TestEnableWriteCompressionDuringWrites(t *testing.T) {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	rc := newTestConn()
+	rc.Compression = true
+	rc.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+	if !rc.IsCompressionEnabled() {
+		t.Fatal("compression was not negotiated")
+	}
+
+	var wg sync.WaitGroup
+	msg := []byte(strings.Repeat("compressible ", 100))
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := rc.WriteMessage(websocket.TextMessage, msg); err != nil {
+					t.Errorf("write: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for enable := false; ; enable = !enable {
+		select {
+		case <-done:
+			return
+		default:
+			rc.EnableWriteCompression(enable)
+		}
+	}
+}
+
+func TestBackoffDecayCountsAttemptsPerSession(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var (
+		mu       sync.Mutex
+		attempts []int
+	)
+	rc := newTestConn()
+	rc.RecIntvlMin = time.Millisecond
+	rc.RecIntvlMax = 5 * time.Millisecond
+	rc.BackoffDecay = true
+	rc.Messages()
+	rc.NextHeaders = func(_ *http.Response, attempt int) (http.Header, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts = append(attempts, attempt)
+		// fail the first two attempts of each session
+		if attempt < 3 {
+			return nil, errors.New("not yet")
+		}
+		return nil, nil
+	}
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	for session := 1; session <= 3; session++ {
+		waitFor(t, "connect", func() bool { return rc.IsConnected() && srv.Connections() == session })
+		if got := rc.GetReconnectAttempt(); got != 3 {
+			t.Errorf("session %d: GetReconnectAttempt = %d, want 3", session, got)
+		}
+		if session < 3 {
+			conn, release := rc.UnderlyingConn()
+			_ = conn.NetConn().Close()
+			release()
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int{1, 2, 3, 1, 2, 3, 1, 2, 3}
+	if len(attempts) != len(want) {
+		t.Fatalf("attempts = %v, want %v", attempts, want)
+	}
+	for i := range want {
+		if attempts[i] != want[i] {
+			t.Fatalf("attempts = %v, want %v", attempts, want)
+		}
+	}
+}
+
+func TestMakeBeforeBreakKeepsConnectionUntilSubscribed(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{Echo: true})
+	defer srv.Close()
+
+	subscribing := make(chan struct{})
+	subscribed := make(chan struct{})
+	rc := newTestConn()
+	rc.MakeBeforeBreak = true
+	rc.SubscribeContextHandler = func(ctx context.Context) error {
+		if srv.Connections() == 1 {
+			return nil
+		}
+		if err := rc.WriteMessageContext(ctx, websocket.TextMessage, []byte("subscribe")); err != nil {
+			return err
+		}
+		close(subscribing)
+		<-subscribed
+		return nil
+	}
+	messages, errs := rc.Messages(), rc.Errors()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+	waitFor(t, "connect", rc.IsConnected)
+
+	receive := func(want string) {
+		t.Helper()
+
+		select {
+		case msg := <-messages:
+			if string(msg.Data) != want {
+				t.Fatalf("got %q, want %q", msg.Data, want)
+			}
+		case err := <-errs:
+			t.Fatalf("got error %v, want %q", err, want)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	rc.ForceReconnect()
+	<-subscribing
+
+	// the old connection is still in use while the new one subscribes
+	if !rc.IsConnected() {
+		t.Fatal("not connected while the new connection subscribes")
+	}
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	receive("old")
+
+	close(subscribed)
+	receive("subscribe")
+	waitFor(t, "reconnect", func() bool { return !rc.IsReconnecting() })
+	if err := rc.WriteMessage(websocket.TextMessage, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	receive("new")
+
+	// the retired connection is closed without reporting its read error
+	select {
+	case err := <-errs:
+		t.Fatalf("got error %v from the retired connection", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMakeBeforeBreakValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		rc      *RecConn
+		invalid bool
+	}{
+		{"SubscribeHandler", &RecConn{
+			MakeBeforeBreak:  true,
+			SubscribeHandler: func() error { return nil },
+		}, true},
+		{"ReadyCheck", &RecConn{
+			MakeBeforeBreak: true,
+			ReadyCheck:      func() error { return nil },
+		}, true},
+		{"SubscribeContextHandler", &RecConn{
+			MakeBeforeBreak:         true,
+			SubscribeContextHandler: func(context.Context) error { return nil },
+		}, false},
+	} {
+		err := test.rc.Validate()
+		if got := errors.Is(err, ErrInvalidConfig); got != test.invalid {
+			t.Errorf("%s: got %v, want invalid %t", test.name, err, test.invalid)
+		}
+	}
+}
+
+func TestRedialResetsDialErrors(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	// a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "ws://" + l.Addr().String()
+	l.Close()
+
+	var (
+		mu       sync.Mutex
+		attempts []int
+	)
+	rc := newTestConn()
+	rc.DialErrorHistorySize = 10
+	rc.NextHeaders = func(_ *http.Response, attempt int) (http.Header, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if rc.GetURL() == srv.WSURL() {
+			attempts = append(attempts, attempt)
+		}
+		return nil, nil
+	}
+	rc.Dial(refused, nil)
+	defer rc.Close()
+	waitFor(t, "failed dials", func() bool { return len(rc.DialErrorHistory()) >= 2 })
+
+	if err := rc.Redial(srv.WSURL(), nil); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "connect", rc.IsConnected)
+
+	if history := rc.DialErrorHistory(); len(history) != 0 {
+		t.Errorf("DialErrorHistory = %v, want empty", history)
+	}
+	if got := rc.GetReconnectAttempt(); got != 1 {
+		t.Errorf("GetReconnectAttempt = %d, want 1", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 1 || attempts[0] != 1 {
+		t.Errorf("attempts = %v, want [1]", attempts)
+	}
+}
@@ -0,0 +1,187 @@
+// Package recwstest provides a fake WebSocket server for testing
+// clients built on recws, such as their reconnect, keepalive and close handling.
+package recwstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options configures the behavior of the Server for each connection.
+type Options struct {
+	// Echo writes every received message back to the client
+	Echo bool
+	// PingInterval specifies the interval of pings sent to the client,
+	// disabled if 0
+	PingInterval time.Duration
+	// CloseAfter closes the connection with the CloseCode after
+	// the given number of received messages, disabled if 0
+	CloseAfter int
+	// CloseCode specifies the code of the close frame sent by CloseAfter,
+	// default to websocket.CloseNormalClosure
+	CloseCode int
+	// DropAfter drops the connection without a close frame after
+	// the given number of received messages, so the client sees an
+	// abnormal closure (1006), disabled if 0
+	DropAfter int
+}
+
+// Server is a fake WebSocket server, started with NewServer.
+type Server struct {
+	*httptest.Server
+
+	opts        Options
+	upgrader    websocket.Upgrader
+	connections atomic.Int64
+	conns       map[*conn]struct{}
+	mu          sync.Mutex
+}
+
+type conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *conn) writeMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// NewServer starts a Server with the options. Call Close when done.
+func NewServer(opts Options) *Server {
+	if opts.CloseCode == 0 {
+		opts.CloseCode = websocket.CloseNormalClosure
+	}
+
+	s := &Server{
+		opts:  opts,
+		conns: make(map[*conn]struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// WSURL returns the ws:// url of the server.
+func (s *Server) WSURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *Server) Connections() int {
+	return int(s.connections.Load())
+}
+
+// Broadcast writes the message to all open connections.
+func (s *Server) Broadcast(messageType int, data []byte) {
+	for _, c := range s.openConns() {
+		_ = c.writeMessage(messageType, data)
+	}
+}
+
+// CloseConns closes all open connections with a close frame of the code and text.
+func (s *Server) CloseConns(code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	for _, c := range s.openConns() {
+		_ = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		_ = c.ws.Close()
+	}
+}
+
+// DropConns drops all open connections without a close frame.
+func (s *Server) DropConns() {
+	for _, c := range s.openConns() {
+		_ = c.ws.NetConn().Close()
+	}
+}
+
+// Close closes all connections and shuts the server down.
+func (s *Server) Close() {
+	s.DropConns()
+	s.Server.Close()
+}
+
+func (s *Server) openConns() []*conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.connections.Add(1)
+
+	c := &conn{ws: ws}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = ws.Close()
+	}()
+
+	if s.opts.PingInterval > 0 {
+		go s.ping(c, done)
+	}
+
+	for received := 1; ; received++ {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if s.opts.Echo {
+			if err := c.writeMessage(messageType, data); err != nil {
+				return
+			}
+		}
+
+		if s.opts.DropAfter > 0 && received >= s.opts.DropAfter {
+			_ = ws.NetConn().Close()
+			return
+		}
+		if s.opts.CloseAfter > 0 && received >= s.opts.CloseAfter {
+			msg := websocket.FormatCloseMessage(s.opts.CloseCode, "")
+			_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		}
+	}
+}
+
+func (s *Server) ping(c *conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
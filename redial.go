@@ -0,0 +1,81 @@
+package recws
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Redial switches the connection to a new url right away, keeping the config
+// and the handlers. The current connection is closed with a close frame,
+// firing the DisconnectHandler, and the connect loop is stopped before the
+// runtime state (counters of connects, resume token, dial errors, backoff)
+// is reset and the new url is dialed like with Dial.
+//
+// Unlike Dial, Redial can be called repeatedly; an invalid url or config
+// is returned before the current connection is closed.
+// After Shutdown ErrNotConnected is returned.
+func (rc *RecConn) Redial(urlStr string, reqHeader http.Header) error {
+	if rc.getIsShutdown() {
+		return ErrNotConnected
+	}
+	if _, err := rc.parseURL(urlStr); err != nil {
+		return err
+	}
+	if err := rc.Validate(); err != nil {
+		return err
+	}
+
+	rc.setIsClosed(true)
+	if conn := rc.getConn(); conn != nil && rc.isTransportUp() {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(rc.getHandshakeTimeout()))
+	}
+	rc.close(true, DisconnectClosed, nil)
+	rc.awaitConnectLoop()
+
+	rc.resetSession()
+	rc.setIsClosed(false)
+
+	if err := rc.configure(urlStr, reqHeader); err != nil {
+		return err
+	}
+
+	rc.startConnect(0)
+	rc.waitFirstAttempt()
+
+	return nil
+}
+
+// awaitConnectLoop waits for a running connect loop to exit
+func (rc *RecConn) awaitConnectLoop() {
+	rc.mu.RLock()
+	done := rc.connectDone
+	rc.mu.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// resetSession resets the runtime state of the previous url
+func (rc *RecConn) resetSession() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.dialed = false
+	rc.lazyPending = false
+	rc.urls = nil
+	rc.httpResp = nil
+	rc.dialErr = nil
+	rc.dialErrors = nil
+	rc.lastDialAt = time.Time{}
+	rc.backoffLevel = 0
+	rc.extensions = nil
+	rc.compressionEnabled = false
+	rc.connectCount = 0
+	rc.reconnectCount = 0
+	rc.lastCloseCode = 0
+	rc.resumeToken = ""
+}
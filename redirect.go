@@ -0,0 +1,80 @@
+package recws
+
+import (
+	"net/http"
+	"net/url"
+)
+
+const defaultMaxRedirects = 10
+
+func (rc *RecConn) getFollowRedirects() (follow bool, maxRedirects int) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	maxRedirects = rc.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	return rc.FollowRedirects, maxRedirects
+}
+
+// redirect replaces the url that responded to the handshake with a redirect
+// by the Location of the redirect. It reports whether the url was replaced.
+func (rc *RecConn) redirect(httpResp *http.Response) bool {
+	if httpResp == nil || httpResp.Request == nil ||
+		httpResp.StatusCode < http.StatusMultipleChoices || httpResp.StatusCode >= http.StatusBadRequest {
+		return false
+	}
+
+	location := httpResp.Header.Get("Location")
+	if location == "" {
+		return false
+	}
+
+	from := httpResp.Request.URL
+	target, err := from.Parse(location)
+	if err != nil {
+		rc.logf("Dial: redirect: %v", err)
+		return false
+	}
+
+	switch target.Scheme {
+	case "http":
+		target.Scheme = "ws"
+	case "https":
+		target.Scheme = "wss"
+	}
+
+	to, err := rc.parseURL(target.String())
+	if err != nil {
+		rc.logf("Dial: redirect: %v", err)
+		return false
+	}
+
+	rc.mu.Lock()
+	replaced := false
+	for i, u := range rc.urls {
+		if sameEndpoint(u, from) {
+			rc.urls[i] = to
+			replaced = true
+		}
+	}
+	if len(rc.urls) == 0 || replaced && sameEndpoint(rc.parsedURL, from) {
+		rc.parsedURL = to
+		rc.url = to.String()
+		replaced = true
+	}
+	rc.mu.Unlock()
+
+	if replaced {
+		rc.verbosef("Dial: redirected to %s", to)
+	}
+
+	return replaced
+}
+
+// sameEndpoint compares a websocket url with the http url of its handshake request
+func sameEndpoint(u, req *url.URL) bool {
+	return u != nil && u.Host == req.Host && u.RequestURI() == req.RequestURI()
+}
@@ -0,0 +1,94 @@
+package recws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// ErrRequestInSubscribe is returned by Request with the ctx of the
+// SubscribeContextHandler, as the background reader only reads once
+// the connection is connected
+var ErrRequestInSubscribe = errors.New("websocket: request in subscribe handler")
+
+type requestWaiter struct {
+	match  func(json.RawMessage) bool
+	result chan json.RawMessage
+}
+
+// Request writes the JSON encoding of req and waits for the first message
+// satisfying match, or until the ctx is done.
+//
+// The response is taken from the background reader, so it is not delivered
+// to the Messages channel, and other messages keep being delivered there.
+// It cannot be used in the SubscribeContextHandler, ErrRequestInSubscribe
+// is returned there.
+//
+// If the connection is closed ErrNotConnected is returned
+func (rc *RecConn) Request(ctx context.Context, req interface{}, match func(json.RawMessage) bool) (json.RawMessage, error) {
+	if ctx.Value(holdBypassKey{}) != nil {
+		return nil, ErrRequestInSubscribe
+	}
+
+	r := rc.getBackgroundReader()
+	w := &requestWaiter{match: match, result: make(chan json.RawMessage, 1)}
+
+	r.addWaiter(w)
+	defer r.removeWaiter(w)
+
+	if err := rc.WriteJSONContext(ctx, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-w.result:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *backgroundReader) addWaiter(w *requestWaiter) {
+	r.waitersMu.Lock()
+	defer r.waitersMu.Unlock()
+
+	r.waiters = append(r.waiters, w)
+}
+
+func (r *backgroundReader) removeWaiter(w *requestWaiter) {
+	r.waitersMu.Lock()
+	defer r.waitersMu.Unlock()
+
+	for i, waiter := range r.waiters {
+		if waiter == w {
+			r.waiters = append(r.waiters[:i:i], r.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// deliverResponse hands the message to the first request waiting for it,
+// and reports whether there was one. A panicking match does not match.
+func (r *backgroundReader) deliverResponse(rc *RecConn, data []byte) bool {
+	if !json.Valid(data) {
+		return false
+	}
+
+	r.waitersMu.Lock()
+	defer r.waitersMu.Unlock()
+
+	for i, w := range r.waiters {
+		var matched bool
+		_ = rc.callHandler("Request match", func() error {
+			matched = w.match(data)
+			return nil
+		})
+		if matched {
+			w.result <- data
+			r.waiters = append(r.waiters[:i:i], r.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package recws
+
+import "net/http"
+
+const defaultResumeTokenHeader = "X-Resume-Token"
+
+// GetResumeToken returns the last token captured by CaptureResumeToken.
+func (rc *RecConn) GetResumeToken() string {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return rc.resumeToken
+}
+
+// captureResumeToken stores the token issued by the server on a successful connect
+func (rc *RecConn) captureResumeToken(httpResp *http.Response) {
+	rc.mu.RLock()
+	capture := rc.CaptureResumeToken
+	rc.mu.RUnlock()
+
+	if capture == nil || httpResp == nil {
+		return
+	}
+
+	var token string
+	_ = rc.callHandler("CaptureResumeToken", func() error {
+		token = capture(httpResp)
+		return nil
+	})
+
+	if token == "" {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.resumeToken = token
+}
+
+// withResumeToken adds the resume token to the request header on reconnects
+func (rc *RecConn) withResumeToken(reqHeader http.Header) http.Header {
+	rc.mu.RLock()
+	isReconnect, resumeToken, token, headerName := rc.connectCount > 0, rc.ResumeToken, rc.resumeToken, rc.ResumeTokenHeader
+	rc.mu.RUnlock()
+
+	if !isReconnect {
+		return reqHeader
+	}
+
+	if resumeToken != nil {
+		_ = rc.callHandler("ResumeToken", func() error {
+			token = resumeToken()
+			return nil
+		})
+	}
+	if token == "" {
+		return reqHeader
+	}
+
+	if headerName == "" {
+		headerName = defaultResumeTokenHeader
+	}
+
+	reqHeader = reqHeader.Clone()
+	if reqHeader == nil {
+		reqHeader = http.Header{}
+	}
+	reqHeader.Set(headerName, token)
+
+	return reqHeader
+}
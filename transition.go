@@ -0,0 +1,60 @@
+package recws
+
+import "sync"
+
+// transition serializes the connect and disconnect callbacks,
+// so they never interleave and a disconnect callback always
+// completes before the next dial attempt.
+type transition struct {
+	connecting bool
+	pending    []func()
+	sync.Mutex
+}
+
+// fireDisconnect runs the disconnect callbacks. While connect callbacks run,
+// they are queued and run after the connect callbacks completed.
+func (rc *RecConn) fireDisconnect(fn func()) {
+	rc.transition.Lock()
+	defer rc.transition.Unlock()
+
+	if rc.transition.connecting {
+		rc.transition.pending = append(rc.transition.pending, fn)
+		return
+	}
+
+	fn()
+}
+
+// beginConnecting waits for running disconnect callbacks and
+// queues further ones until endConnecting is called.
+func (rc *RecConn) beginConnecting() {
+	rc.transition.Lock()
+	defer rc.transition.Unlock()
+
+	rc.transition.connecting = true
+}
+
+// endConnecting runs the disconnect callbacks queued during the connect callbacks
+func (rc *RecConn) endConnecting() {
+	for {
+		rc.transition.Lock()
+		pending := rc.transition.pending
+		rc.transition.pending = nil
+		if len(pending) == 0 {
+			rc.transition.connecting = false
+			rc.transition.Unlock()
+			return
+		}
+		rc.transition.Unlock()
+
+		for _, fn := range pending {
+			fn()
+		}
+	}
+}
+
+// awaitDisconnect waits for running disconnect callbacks to complete
+func (rc *RecConn) awaitDisconnect() {
+	rc.transition.Lock()
+	defer rc.transition.Unlock()
+}
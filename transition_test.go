@@ -0,0 +1,69 @@
+package recws
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/recws-org/recws/recwstest"
+)
+
+func TestCallbackOrdering(t *testing.T) {
+	srv := recwstest.NewServer(recwstest.Options{})
+	defer srv.Close()
+
+	var (
+		mu    sync.Mutex
+		steps []string
+	)
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+	recorded := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), steps...)
+	}
+
+	rc := newTestConn()
+	rc.NextHeaders = func(*http.Response, int) (http.Header, error) {
+		record("dial")
+		return nil, nil
+	}
+	rc.DisconnectHandler = func() {
+		record("disconnect-start")
+		time.Sleep(20 * time.Millisecond)
+		record("disconnect-end")
+	}
+	var connects int
+	rc.AddConnectListener(func() {
+		record("connect-start")
+		connects++
+		if connects == 1 {
+			// drop the connection while the connect callbacks still run,
+			// on the client as the server may not track it yet
+			conn, release := rc.UnderlyingConn()
+			_ = conn.NetConn().Close()
+			release()
+			time.Sleep(50 * time.Millisecond)
+		}
+		record("connect-end")
+	})
+	rc.Messages()
+	rc.Dial(srv.WSURL(), nil)
+	defer rc.Close()
+
+	want := []string{
+		"dial", "connect-start", "connect-end",
+		"disconnect-start", "disconnect-end",
+		"dial", "connect-start", "connect-end",
+	}
+	waitFor(t, "reconnect", func() bool { return len(recorded()) >= len(want) })
+	if got := recorded(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got callbacks %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package recws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidConfig is wrapped by the errors returned from Validate
+var ErrInvalidConfig = errors.New("websocket: invalid config")
+
+// Validate checks the configuration for consistency, taking the defaults
+// of unset fields into account. Dial and its variants call it before connecting.
+func (rc *RecConn) Validate() error {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	for _, field := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"RecIntvlMin", rc.RecIntvlMin},
+		{"RecIntvlMax", rc.RecIntvlMax},
+		{"FirstReconnectDelay", rc.FirstReconnectDelay},
+		{"MinReconnectGap", rc.MinReconnectGap},
+		{"HandshakeTimeout", rc.HandshakeTimeout},
+		{"DialDeadline", rc.DialDeadline},
+		{"SubscribeDelay", rc.SubscribeDelay},
+		{"SubscribeTimeout", rc.SubscribeTimeout},
+		{"KeepAliveTimeout", rc.KeepAliveTimeout},
+		{"PongTimeout", rc.PongTimeout},
+	} {
+		if field.d < 0 {
+			invalid("%s must not be negative, got %v", field.name, field.d)
+		}
+	}
+
+	recIntvlMin, recIntvlMax := rc.RecIntvlMin, rc.RecIntvlMax
+	if recIntvlMin == 0 {
+		recIntvlMin = 2 * time.Second
+	}
+	if recIntvlMax == 0 {
+		recIntvlMax = 30 * time.Second
+	}
+	if recIntvlMax < recIntvlMin {
+		invalid("RecIntvlMax %v is less than RecIntvlMin %v", recIntvlMax, recIntvlMin)
+	}
+	if rc.RecIntvlFactor < 0 {
+		invalid("RecIntvlFactor must not be negative, got %v", rc.RecIntvlFactor)
+	}
+	if rc.JitterFraction < 0 || rc.JitterFraction > 1 {
+		invalid("JitterFraction must be between 0 and 1, got %v", rc.JitterFraction)
+	}
+	if rc.MaxReconnectAttempts < 0 {
+		invalid("MaxReconnectAttempts must not be negative, got %d", rc.MaxReconnectAttempts)
+	}
+	if rc.MaxRedirects < 0 {
+		invalid("MaxRedirects must not be negative, got %d", rc.MaxRedirects)
+	}
+	switch rc.DialNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		invalid("DialNetwork must be one of tcp, tcp4 or tcp6, got %q", rc.DialNetwork)
+	}
+
+	if rc.HoldWritesUntilSubscribed && (rc.SubscribeContextHandler == nil || rc.ReadyCheck != nil) {
+		invalid("HoldWritesUntilSubscribed requires a SubscribeContextHandler and no ReadyCheck")
+	}
+	if rc.MakeBeforeBreak && (rc.SubscribeHandler != nil || rc.ReadyCheck != nil) {
+		invalid("MakeBeforeBreak requires no SubscribeHandler and no ReadyCheck")
+	}
+
+	if rc.ReadDeadlineOnPong && rc.KeepAliveTimeout > 0 {
+		pongTimeout := rc.PongTimeout
+		if pongTimeout == 0 {
+			pongTimeout = 60 * time.Second
+		}
+		if rc.KeepAliveTimeout >= pongTimeout {
+			invalid("KeepAliveTimeout %v must be less than PongTimeout %v with ReadDeadlineOnPong", rc.KeepAliveTimeout, pongTimeout)
+		}
+	}
+
+	return errors.Join(errs...)
+}